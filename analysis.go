@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+)
+
+const MESH_REPORT_LINES int = 11 // Lines returned by MeshReport.Lines
+
+// Faces are degenerate when their area is below this fraction of the squared bounding box diagonal
+const DEGENERATE_AREA_RATIO float64 = 1e-12
+
+// MeshReport holds the measurements and the topology problems of a mesh. Vertices with the same
+// position are treated as one, so seams of normals or texture coordinates don't open the surface.
+type MeshReport struct {
+	Faces     int `json:"faces"`
+	Triangles int `json:"triangles"`
+	Vertices  int `json:"vertices"`
+
+	BoundsMin   [3]float64 `json:"bounds_min"`
+	BoundsMax   [3]float64 `json:"bounds_max"`
+	Dimensions  [3]float64 `json:"dimensions"`
+	SurfaceArea float64    `json:"surface_area"`
+	Volume      float64    `json:"volume"` // Signed, negative when the faces point inwards. Only meaningful for closed meshes
+
+	Components          int `json:"components"`            // Groups of faces connected by their vertices
+	BoundaryEdges       int `json:"boundary_edges"`        // Edges of a single face, where the surface is open
+	NonManifoldEdges    int `json:"non_manifold_edges"`    // Edges shared by more than 2 faces
+	NonManifoldVertices int `json:"non_manifold_vertices"` // Vertices where separate fans of faces touch
+	DegenerateFaces     int `json:"degenerate_faces"`      // Triangles without area
+	DuplicateFaces      int `json:"duplicate_faces"`       // Triangles with the same vertices as a previous one
+	FlippedRegions      int `json:"flipped_regions"`       // Regions wound against the rest of their surface
+	UnusedVertices      int `json:"unused_vertices"`       // Vertices of the file no face uses
+}
+
+// Issues returns whether the report found any topology problem.
+func (r *MeshReport) Issues() bool {
+	return r.BoundaryEdges > 0 || r.NonManifoldEdges > 0 || r.NonManifoldVertices > 0 ||
+		r.DegenerateFaces > 0 || r.DuplicateFaces > 0 || r.FlippedRegions > 0 || r.UnusedVertices > 0
+}
+
+// Lines returns the report as text lines, as shown by the viewer and the inspect command.
+func (r *MeshReport) Lines() []string {
+	return []string{
+		fmt.Sprintf("Size: %s x %s x %s", formatMeasure(r.Dimensions[0]), formatMeasure(r.Dimensions[1]), formatMeasure(r.Dimensions[2])),
+		fmt.Sprintf("Surface area: %s", formatMeasure(r.SurfaceArea)),
+		fmt.Sprintf("Volume: %s", formatMeasure(r.Volume)),
+		fmt.Sprintf("Components: %d", r.Components),
+		fmt.Sprintf("Open edges: %d", r.BoundaryEdges),
+		fmt.Sprintf("Non-manifold edges: %d", r.NonManifoldEdges),
+		fmt.Sprintf("Non-manifold vertices: %d", r.NonManifoldVertices),
+		fmt.Sprintf("Degenerate faces: %d", r.DegenerateFaces),
+		fmt.Sprintf("Duplicate faces: %d", r.DuplicateFaces),
+		fmt.Sprintf("Flipped regions: %d", r.FlippedRegions),
+		fmt.Sprintf("Unused vertices: %d", r.UnusedVertices),
+	}
+}
+
+func (r *MeshReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Faces: %d\nTriangles: %d\nVertices: %d\n", r.Faces, r.Triangles, r.Vertices)
+	fmt.Fprintf(w, "Bounds: (%.6g, %.6g, %.6g) to (%.6g, %.6g, %.6g)\n",
+		r.BoundsMin[0], r.BoundsMin[1], r.BoundsMin[2], r.BoundsMax[0], r.BoundsMax[1], r.BoundsMax[2])
+	for _, line := range r.Lines() {
+		fmt.Fprintln(w, line)
+	}
+}
+
+func formatMeasure(value float64) string {
+	return fmt.Sprintf("%.4g", value)
+}
+
+// Counts of the faces using an edge, between welded vertices a < b
+type meshEdge struct {
+	faces   int32
+	forward int32 // Faces going from a to b, the rest go from b to a
+	first   int32 // First 2 faces using it
+	second  int32
+}
+
+// AnalyzeMesh measures the mesh and checks its topology, reporting its progress as the
+// LOAD_ANALYZING phase.
+func AnalyzeMesh(ctx context.Context, mesh *Mesh) (MeshReport, error) {
+	r := MeshReport{
+		Faces:          mesh.faceAmount,
+		Triangles:      mesh.triangleAmount,
+		Vertices:       mesh.vertexAmount,
+		UnusedVertices: mesh.unusedVertices,
+	}
+	if len(mesh.positions) == 0 {
+		return r, nil
+	}
+
+	r.BoundsMin = [3]float64{mesh.lowestX, mesh.lowestY, mesh.lowestZ}
+	r.BoundsMax = [3]float64{mesh.highestX, mesh.highestY, mesh.highestZ}
+	for i := range r.Dimensions {
+		r.Dimensions[i] = r.BoundsMax[i] - r.BoundsMin[i]
+	}
+	if mesh.isPointCloud() {
+		return r, nil
+	}
+
+	// Weld the vertices with the same position
+	welded := make([]int32, len(mesh.positions))
+	ids := make(map[[3]float64]int32, len(mesh.positions))
+	for i, p := range mesh.positions {
+		id, ok := ids[p]
+		if !ok {
+			id = int32(len(ids))
+			ids[p] = id
+		}
+		welded[i] = id
+	}
+	weldedAmount := len(ids)
+
+	faces := make([][3]int32, 0, mesh.triangleAmount)
+	for s := range mesh.submeshes {
+		indices := mesh.submeshes[s].indices
+		for i := 0; i+2 < len(indices); i += 3 {
+			faces = append(faces, [3]int32{welded[indices[i]], welded[indices[i+1]], welded[indices[i+2]]})
+		}
+	}
+
+	startPhase(ctx, LOAD_ANALYZING, len(faces))
+
+	diagonal := NewVector4(r.Dimensions[0], r.Dimensions[1], r.Dimensions[2], 0).Len()
+	minArea := DEGENERATE_AREA_RATIO * diagonal * diagonal
+
+	weldedPositions := make([][3]float64, weldedAmount)
+	for i, id := range welded {
+		weldedPositions[id] = mesh.positions[i]
+	}
+
+	vertices := newUnionFind(weldedAmount)
+	corners := newUnionFind(3 * len(faces)) // Corners of the faces around each vertex, joined by their shared edges
+	edges := make(map[[2]int32]meshEdge, len(faces)*3/2)
+	sortedFaces := make(map[[3]int32]bool, len(faces))
+	used := make([]bool, weldedAmount)
+	valid := make([]bool, len(faces))
+
+	for f, face := range faces {
+		if f%PROGRESS_INTERVAL == 0 {
+			if err := reportProgress(ctx, f); err != nil {
+				return r, err
+			}
+		}
+
+		p0, p1, p2 := weldedPositions[face[0]], weldedPositions[face[1]], weldedPositions[face[2]]
+		a := NewVector4(p0[0], p0[1], p0[2], 0)
+		b := NewVector4(p1[0], p1[1], p1[2], 0)
+		c := NewVector4(p2[0], p2[1], p2[2], 0)
+
+		area := b.Sub(a).CrossProduct(c.Sub(a)).Len() / 2
+		r.SurfaceArea += area
+		r.Volume += a.Dot(b.CrossProduct(c)) / 6
+
+		for _, v := range face {
+			used[v] = true
+		}
+
+		if area <= minArea {
+			r.DegenerateFaces++
+		}
+		if face[0] == face[1] || face[1] == face[2] || face[2] == face[0] {
+			// Faces collapsed to an edge or a point have no edges of their own
+			continue
+		}
+
+		// Duplicates are left out of the edge checks, so they are not also reported as
+		// non-manifold or flipped
+		sorted := face
+		slices.Sort(sorted[:])
+		if sortedFaces[sorted] {
+			r.DuplicateFaces++
+			continue
+		}
+		sortedFaces[sorted] = true
+		valid[f] = true
+
+		for j := range face {
+			v0, v1 := face[j], face[(j+1)%3]
+			vertices.union(v0, v1)
+
+			key := [2]int32{min(v0, v1), max(v0, v1)}
+			edge, ok := edges[key]
+			if !ok {
+				edge.first, edge.second = int32(f), -1
+			} else {
+				// Join the corners of both faces at each end of the edge
+				first := faces[edge.first]
+				corners.union(int32(3*f+j), int32(3*int(edge.first)+slices.Index(first[:], v0)))
+				corners.union(int32(3*f+(j+1)%3), int32(3*int(edge.first)+slices.Index(first[:], v1)))
+				if edge.second < 0 {
+					edge.second = int32(f)
+				}
+			}
+
+			edge.faces++
+			if v0 < v1 {
+				edge.forward++
+			}
+			edges[key] = edge
+		}
+	}
+
+	r.UnusedVertices += weldedAmount - countTrue(used)
+
+	// Surfaces are the faces connected through manifold edges, and regions the ones connected
+	// through manifold edges that also keep the winding order
+	surfaces := newUnionFind(len(faces))
+	regions := newUnionFind(len(faces))
+	for _, edge := range edges {
+		switch {
+		case edge.faces == 1:
+			r.BoundaryEdges++
+		case edge.faces > 2:
+			r.NonManifoldEdges++
+		default:
+			surfaces.union(edge.first, edge.second)
+			if edge.forward == 1 {
+				regions.union(edge.first, edge.second)
+			}
+		}
+	}
+
+	componentRoots := map[int32]bool{}
+	for v := range used {
+		if used[v] {
+			componentRoots[vertices.find(int32(v))] = true
+		}
+	}
+	r.Components = len(componentRoots)
+
+	surfaceRoots, regionRoots := map[int32]bool{}, map[int32]bool{}
+	for f := range faces {
+		if valid[f] {
+			surfaceRoots[surfaces.find(int32(f))] = true
+			regionRoots[regions.find(int32(f))] = true
+		}
+	}
+	r.FlippedRegions = len(regionRoots) - len(surfaceRoots)
+
+	// A vertex is non-manifold when its corners form more than one fan
+	fans := make([]int32, weldedAmount)
+	for i := range fans {
+		fans[i] = -1
+	}
+	nonManifold := make([]bool, weldedAmount)
+	for f, face := range faces {
+		if !valid[f] {
+			continue
+		}
+		for j, v := range face {
+			fan := corners.find(int32(3*f + j))
+			if fans[v] < 0 {
+				fans[v] = fan
+			} else if fans[v] != fan {
+				nonManifold[v] = true
+			}
+		}
+	}
+	r.NonManifoldVertices = countTrue(nonManifold)
+
+	return r, reportProgress(ctx, len(faces))
+}
+
+func countTrue(values []bool) int {
+	n := 0
+	for _, value := range values {
+		if value {
+			n++
+		}
+	}
+
+	return n
+}
+
+// unionFind groups elements into disjoint sets.
+type unionFind []int32
+
+func newUnionFind(n int) unionFind {
+	u := make(unionFind, n)
+	for i := range u {
+		u[i] = int32(i)
+	}
+
+	return u
+}
+
+// find returns the representative element of the set of i.
+func (u unionFind) find(i int32) int32 {
+	for u[i] != i {
+		u[i] = u[u[i]]
+		i = u[i]
+	}
+
+	return i
+}
+
+func (u unionFind) union(a, b int32) {
+	a, b = u.find(a), u.find(b)
+	if a != b {
+		u[max(a, b)] = min(a, b)
+	}
+}
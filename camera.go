@@ -0,0 +1,188 @@
+package main
+
+import (
+	"math"
+)
+
+const (
+	CAMERA_FIT_MARGIN   float64 = 1.2  // Extra distance when framing a mesh, relative to its size
+	CAMERA_ZOOM_STEP    float64 = 0.85 // Distance factor of each mouse wheel step
+	MIN_CAMERA_DISTANCE float64 = 1e-3
+
+	FLY_LOOK_SPEED  float64 = 0.003 // Radians per pixel of mouse motion
+	FLY_MAX_PITCH   float64 = 89 * math.Pi / 180
+	FLY_FAST_FACTOR float64 = 4 // Speed multipliers of the modifier keys
+	FLY_SLOW_FACTOR float64 = 0.25
+	FLY_SPEED_STEP  float64 = 1.25 // Speed factor of each mouse wheel step
+)
+
+// Camera orbits around a target point of the model, looking at it from a distance, or flies
+// through it in fly mode. The view space has the camera at the origin looking towards +z, with
+// +x to the left of the screen and +y up.
+type Camera struct {
+	target      Vector4 // Orbit pivot, in model coordinates
+	distance    float64
+	orientation Quaternion // Rotation of the model around the target, as seen by the camera
+
+	fly        bool
+	eye        Vector4 // Position of the camera in fly mode, in model coordinates
+	yaw, pitch float64 // Look direction in fly mode, around the model vertical axis and up from the horizon
+	flySpeed   float64 // Model units per second
+}
+
+// DefaultCamera returns the camera framing the whole mesh, seen from its front.
+func DefaultCamera(mesh *Mesh) Camera {
+	c := Camera{
+		target:      NewVector4(0, -DEFAULT_Y_OFFSET, 0, 1),
+		distance:    DEFAULT_Z_OFFSET,
+		orientation: NewQuaternion(NewVector4(0, 1, 0, 0), DEFAULT_Y_ROTATION),
+	}
+
+	if mesh != nil && len(mesh.positions) > 0 {
+		c.target = NewVector4((mesh.lowestX+mesh.highestX)/2, (mesh.lowestY+mesh.highestY)/2, (mesh.lowestZ+mesh.highestZ)/2, 1)
+
+		// Distance at which the bounding sphere of the mesh fits in the field of view
+		radius := NewVector4(mesh.highestX-mesh.lowestX, mesh.highestY-mesh.lowestY, mesh.highestZ-mesh.lowestZ, 0).Len() / 2
+		c.distance = math.Max(MIN_CAMERA_DISTANCE, radius*CAMERA_FIT_MARGIN/math.Sin(degToRad(FOV_DEGREES/2)))
+	}
+
+	return c
+}
+
+// World returns the matrix transforming the model into view space.
+func (c *Camera) World() mat44 {
+	if c.fly {
+		return lookAtMatrix(c.eye, c.eye.Add(c.forward()), NewVector4(0, 1, 0, 0))
+	}
+
+	return MakeTranslation(-c.target.x, -c.target.y, -c.target.z).
+		multiplyMatrix(c.orientation.matrix()).
+		multiplyMatrix(MakeTranslation(0, 0, c.distance))
+}
+
+// Rotate turns the model around the target, by angle radians around the view space axis.
+func (c *Camera) Rotate(axis Vector4, angle float64) {
+	c.orientation = NewQuaternion(axis, angle).Mul(c.orientation).Normalise()
+}
+
+// Orbit turns the model around the target by yaw radians around the vertical axis, then by
+// pitch radians around the horizontal one.
+func (c *Camera) Orbit(yaw, pitch float64) {
+	c.Rotate(NewVector4(0, 1, 0, 0), yaw)
+	c.Rotate(NewVector4(1, 0, 0, 0), pitch)
+}
+
+// Arcball turns the model as if the cursor dragged a sphere centered on the screen from one
+// position to the other. Positions and sizes are in screen pixels.
+func (c *Camera) Arcball(fromX, fromY, toX, toY, width, height float64) {
+	from := arcballPoint(fromX, fromY, width, height)
+	to := arcballPoint(toX, toY, width, height)
+
+	angle := math.Acos(math.Max(-1, math.Min(1, from.Dot(to))))
+	if angle == 0 {
+		return
+	}
+
+	c.Rotate(from.CrossProduct(to), angle)
+}
+
+// arcballPoint returns the view space direction of the screen position on the arcball. Outside
+// of the sphere it follows a hyperbolic sheet, so the rotation stays continuous.
+func arcballPoint(x, y, width, height float64) Vector4 {
+	radius := math.Min(width, height) / 2
+	nx := (x - width/2) / radius
+	ny := (y - height/2) / radius
+
+	var z float64
+	if d := nx*nx + ny*ny; d <= 0.5 {
+		z = math.Sqrt(1 - d)
+	} else {
+		z = 0.5 / math.Sqrt(d)
+	}
+
+	// The front of the sphere faces the camera, towards -z
+	return NewVector4(-nx, -ny, -z, 0).Normalise()
+}
+
+// unitsPerPixel returns the view space size of a screen pixel at the target distance.
+func (c *Camera) unitsPerPixel(height float64) float64 {
+	return 2 * math.Tan(degToRad(FOV_DEGREES/2)) / height * c.distance
+}
+
+// viewToModel converts a view space direction into model coordinates.
+func (c *Camera) viewToModel(v Vector4) Vector4 {
+	return c.orientation.Conjugate().Rotate(v)
+}
+
+// Pan moves the model along the screen by the given pixels, so it follows the cursor.
+func (c *Camera) Pan(dx, dy, height float64) {
+	scale := c.unitsPerPixel(height)
+	c.target = c.target.Sub(c.viewToModel(NewVector4(-dx*scale, -dy*scale, 0, 0)))
+}
+
+// Zoom multiplies the distance to the target by the factor, keeping the point under the cursor
+// in place. Positions and sizes are in screen pixels.
+func (c *Camera) Zoom(factor, x, y, width, height float64) {
+	factor = math.Max(factor, MIN_CAMERA_DISTANCE/c.distance)
+
+	scale := c.unitsPerPixel(height)
+	offset := NewVector4(-(x-width/2)*scale, -(y-height/2)*scale, 0, 0)
+
+	c.target = c.target.Add(c.viewToModel(offset.Mul(1 - factor)))
+	c.distance *= factor
+}
+
+// SetPivot makes the view space point the new orbit target, moving the camera sideways to
+// center it.
+func (c *Camera) SetPivot(point Vector4) {
+	if point.z <= MIN_CAMERA_DISTANCE {
+		return
+	}
+
+	c.target = c.target.Add(c.viewToModel(NewVector4(point.x, point.y, point.z-c.distance, 0)))
+	c.distance = point.z
+}
+
+// SetFly switches between orbiting the target and flying through the model, keeping the camera
+// position and look direction. Fly mode keeps the horizon level, so any roll of the orbit view is
+// reset, and looking straight up or down is clamped to FLY_MAX_PITCH.
+func (c *Camera) SetFly(fly bool) {
+	if fly == c.fly {
+		return
+	}
+
+	if fly {
+		c.eye = c.target.Sub(c.viewToModel(NewVector4(0, 0, c.distance, 0)))
+
+		forward := c.viewToModel(NewVector4(0, 0, 1, 0))
+		c.yaw = math.Atan2(forward.x, forward.z)
+		c.pitch = math.Max(-FLY_MAX_PITCH, math.Min(FLY_MAX_PITCH, math.Asin(math.Max(-1, math.Min(1, forward.y)))))
+		c.flySpeed = c.distance
+	} else {
+		// Orbit around the point in front of the camera, at the previous orbit distance
+		c.orientation = quaternionFromMatrix(c.World())
+		c.target = c.eye.Add(c.forward().Mul(c.distance))
+	}
+
+	c.fly = fly
+}
+
+// forward returns the fly mode look direction, in model coordinates.
+func (c *Camera) forward() Vector4 {
+	return NewVector4(math.Sin(c.yaw)*math.Cos(c.pitch), math.Sin(c.pitch), math.Cos(c.yaw)*math.Cos(c.pitch), 0)
+}
+
+// Look turns the fly mode camera by the mouse motion, in pixels.
+func (c *Camera) Look(dx, dy float64) {
+	c.yaw -= dx * FLY_LOOK_SPEED
+	c.pitch = math.Max(-FLY_MAX_PITCH, math.Min(FLY_MAX_PITCH, c.pitch-dy*FLY_LOOK_SPEED))
+}
+
+// Fly moves the fly mode camera along its look direction, to its right and along the model
+// vertical axis, by the given model units.
+func (c *Camera) Fly(forward, right, up float64) {
+	f := c.forward()
+	left := NewVector4(0, 1, 0, 0).CrossProduct(f).Normalise()
+
+	c.eye = c.eye.Add(f.Mul(forward)).Sub(left.Mul(right)).Add(NewVector4(0, up, 0, 0))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSetFlyKeepsTheView(t *testing.T) {
+	c := Camera{target: NewVector4(1, 2, 3, 1), distance: 5, orientation: identityQuaternion()}
+	c.Orbit(0.5, 0.3)
+	want := c.World()
+
+	for _, fly := range []bool{true, false} {
+		c.SetFly(fly)
+		got := c.World()
+		for i := range want.m {
+			for j := range want.m[i] {
+				if math.Abs(got.m[i][j]-want.m[i][j]) > 1e-9 {
+					t.Fatalf("fly %v: world matrix is %v, want %v", fly, got.m, want.m)
+				}
+			}
+		}
+	}
+}
+
+func TestSetFlyResetsTheRoll(t *testing.T) {
+	c := Camera{target: NewVector4(1, 2, 3, 1), distance: 5, orientation: identityQuaternion()}
+	c.Orbit(0.5, 0.3)
+	c.Rotate(NewVector4(0, 0, 1, 0), 0.4)
+
+	c.SetFly(true)
+	world := c.World()
+
+	// The target stays in front of the camera, and the model vertical axis has no sideways tilt
+	target := world.multiplyVector(c.target)
+	if math.Abs(target.x) > 1e-9 || math.Abs(target.y) > 1e-9 || math.Abs(target.z-c.distance) > 1e-9 {
+		t.Errorf("target is at %v %v %v in view space, want 0 0 %v", target.x, target.y, target.z, c.distance)
+	}
+	up := world.multiplyVector(NewVector4(0, 1, 0, 0))
+	if math.Abs(up.x) > 1e-9 {
+		t.Errorf("vertical axis leans by %v in view space", up.x)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Commands that can be run from the command line without opening the viewer window,
+// as in '3d-viewer <command> [arguments]'.
+var commands = map[string]func(args []string) error{
+	"render":  runRender,
+	"inspect": runInspect,
+	"convert": runConvert,
+}
+
+func runRender(args []string) error {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	output := flags.String("o", "out.png", "output PNG file")
+	size := flags.String("size", fmt.Sprintf("%dx%d", DEFAULT_SCREEN_WIDTH, DEFAULT_SCREEN_HEIGHT), "output resolution, as WIDTHxHEIGHT")
+	yaw := flags.Float64("yaw", 0, "model rotation around the vertical axis, in degrees")
+	pitch := flags.Float64("pitch", 0, "model rotation around the horizontal axis, in degrees")
+	flip := flags.Bool("flip-normals", false, "render the back faces instead of the front ones")
+	shading := flags.String("shading", SHADING_PHONG.String(), "shading mode: flat, gouraud or phong")
+	filter := flags.String("filter", FILTER_TRILINEAR.String(), "texture filter: nearest, bilinear or trilinear")
+	mode := flags.String("mode", "shaded", "render mode: shaded, wireframe, hidden-line or shaded-edges")
+	lineColor := flags.String("line-color", "#ffffff", "wireframe line color, as #rrggbb")
+	lineWidth := flags.Float64("line-width", 1, "wireframe line width, in pixels")
+	pointSize := flags.Float64("point-size", 1, "point cloud point size, in pixels")
+	shadows := flags.Bool("shadows", false, "render the shadows of the directional and spot lights")
+	shadowBias := flags.Float64("shadow-bias", DEFAULT_SHADOW_BIAS, "shadow depth bias, in shadow map texels")
+	ssao := flags.Bool("ssao", false, "darken the cavities and crevices with screen space ambient occlusion")
+	ssaoRadius := flags.Float64("ssao-radius", DEFAULT_SSAO_RADIUS, "ambient occlusion radius, relative to the model radius")
+	ssaoSamples := flags.Int("ssao-samples", DEFAULT_SSAO_SAMPLES, "ambient occlusion samples per pixel")
+	ssaoBlur := flags.Int("ssao-blur", DEFAULT_SSAO_BLUR, "ambient occlusion blur radius, in pixels")
+
+	files, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return errors.New("usage: 3d-viewer render <model file> [-o out.png] [--size 1280x720] [--yaw degrees] [--pitch degrees] [--shading phong] [--filter trilinear] [--mode shaded-edges] [--line-color #ffffff] [--line-width 1] [--point-size 1] [--shadows] [--shadow-bias 1.5] [--ssao] [--ssao-radius 0.1] [--ssao-samples 16] [--ssao-blur 2]")
+	}
+
+	width, height, err := parseSize(*size)
+	if err != nil {
+		return err
+	}
+
+	shadingMode, err := ParseShadingMode(*shading)
+	if err != nil {
+		return err
+	}
+
+	textureFilter, err := ParseTextureFilter(*filter)
+	if err != nil {
+		return err
+	}
+
+	renderMode, err := ParseRenderMode(*mode)
+	if err != nil {
+		return err
+	}
+
+	lineRGBA, err := ParseLineColor(*lineColor)
+	if err != nil {
+		return err
+	}
+
+	mesh, err := LoadMesh(context.Background(), files[0])
+	if err != nil {
+		return err
+	}
+
+	camera := DefaultCamera(mesh)
+	camera.Orbit(degToRad(*yaw), degToRad(*pitch))
+
+	renderer := NewRenderer(width, height)
+	renderer.flipNormals = *flip
+	renderer.shading = shadingMode
+	renderer.filter = textureFilter
+	renderer.mode = renderMode
+	renderer.lineColor = lineRGBA
+	renderer.lineWidth = *lineWidth
+	renderer.pointSize = *pointSize
+	renderer.shadows = *shadows
+	renderer.shadowBias = *shadowBias
+	renderer.ssao = *ssao
+	renderer.ssaoRadius = *ssaoRadius
+	renderer.ssaoSamples = *ssaoSamples
+	renderer.ssaoBlur = *ssaoBlur
+	renderer.DrawMesh(mesh, camera.World())
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(file, renderer.Image()); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// runInspect prints the statistics and topology problems of a model.
+func runInspect(args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+
+	files, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return errors.New("usage: 3d-viewer inspect <model file> [--json]")
+	}
+
+	mesh, err := LoadMesh(context.Background(), files[0])
+	if err != nil {
+		return err
+	}
+
+	report, err := AnalyzeMesh(context.Background(), mesh)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	report.WriteText(os.Stdout)
+	return nil
+}
+
+// runConvert loads a model and saves it in the format of the output file extension.
+func runConvert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	ascii := flags.Bool("ascii", false, "write ASCII instead of binary STL and PLY files")
+
+	files, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 2 {
+		return errors.New("usage: 3d-viewer convert <input model> <output model> [--ascii]")
+	}
+
+	if _, ok := meshEncoderByExtension(files[1]); !ok {
+		return fmt.Errorf("%w '%s'", ErrUnknownMeshFormat, filepath.Ext(files[1]))
+	}
+
+	mesh, err := LoadMesh(context.Background(), files[0])
+	if err != nil {
+		return err
+	}
+
+	return SaveMesh(files[1], mesh, EncodeOptions{ASCII: *ascii})
+}
+
+// parseFlags parses the flags of a command, allowing them to appear before or after
+// the positional arguments, which are returned.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func parseSize(size string) (int, int, error) {
+	parts := strings.Split(strings.ToLower(size), "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid size '%s', expected WIDTHxHEIGHT", size)
+	}
+
+	width, err := strconv.Atoi(parts[0])
+	if err != nil || width <= 0 {
+		return 0, 0, fmt.Errorf("invalid width in size '%s'", size)
+	}
+
+	height, err := strconv.Atoi(parts[1])
+	if err != nil || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid height in size '%s'", size)
+	}
+
+	return width, height, nil
+}
@@ -0,0 +1,837 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	GLB_MAGIC      uint32 = 0x46546c67 // "glTF"
+	GLB_CHUNK_JSON uint32 = 0x4e4f534a // "JSON"
+	GLB_CHUNK_BIN  uint32 = 0x004e4942 // "BIN\0"
+)
+
+// Primitive modes that are rendered, points and lines are skipped
+const (
+	GLTF_MODE_TRIANGLES      int = 4
+	GLTF_MODE_TRIANGLE_STRIP int = 5
+	GLTF_MODE_TRIANGLE_FAN   int = 6
+)
+
+// Accessor component types
+const (
+	GLTF_BYTE           int = 5120
+	GLTF_UNSIGNED_BYTE  int = 5121
+	GLTF_SHORT          int = 5122
+	GLTF_UNSIGNED_SHORT int = 5123
+	GLTF_UNSIGNED_INT   int = 5125
+	GLTF_FLOAT          int = 5126
+)
+
+// Largest number of elements of accessors without a buffer view, which are not bounded by the
+// file size (their sparse values are never more than their elements)
+const MAX_GLTF_ZERO_ACCESSOR int = 1 << 24
+
+var gltfComponentSizes = map[int]int{
+	GLTF_BYTE: 1, GLTF_UNSIGNED_BYTE: 1, GLTF_SHORT: 2, GLTF_UNSIGNED_SHORT: 2, GLTF_UNSIGNED_INT: 4, GLTF_FLOAT: 4,
+}
+
+// Sampler wrap modes
+var gltfWrapModes = map[int]WrapMode{
+	33071: WRAP_CLAMP,
+	33648: WRAP_MIRROR,
+	10497: WRAP_REPEAT,
+}
+
+var gltfTypeComponents = map[string]int{
+	"SCALAR": 1, "VEC2": 2, "VEC3": 3, "VEC4": 4, "MAT2": 4, "MAT3": 9, "MAT4": 16,
+}
+
+// Required extensions the loader can handle (quantized attributes are read like any other accessor)
+var gltfSupportedExtensions = map[string]bool{
+	"KHR_mesh_quantization": true,
+}
+
+// Subset of the glTF 2.0 document used by the viewer
+type gltfDocument struct {
+	ExtensionsRequired []string
+	Scene              *int
+	Scenes             []struct{ Nodes []int }
+	Nodes              []gltfNode
+	Meshes             []struct{ Primitives []gltfPrimitive }
+	Accessors          []gltfAccessor
+	BufferViews        []gltfBufferView
+	Buffers            []struct{ Uri string }
+	Materials          []gltfMaterial
+	Textures           []struct{ Source, Sampler *int }
+	Samplers           []struct{ WrapS, WrapT *int }
+	Images             []gltfImage
+}
+
+type gltfNode struct {
+	Children    []int
+	Mesh        *int
+	Matrix      []float64 // Column major
+	Translation []float64
+	Rotation    []float64 // Quaternion (x, y, z, w)
+	Scale       []float64
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int
+	Indices    *int
+	Material   *int
+	Mode       *int
+}
+
+type gltfAccessor struct {
+	BufferView    *int
+	ByteOffset    int
+	ComponentType int
+	Normalized    bool
+	Count         int
+	Type          string
+	Sparse        *struct {
+		Count   int
+		Indices struct{ BufferView, ByteOffset, ComponentType int }
+		Values  struct{ BufferView, ByteOffset int }
+	}
+}
+
+type gltfBufferView struct {
+	Buffer     int
+	ByteOffset int
+	ByteLength int
+	ByteStride int
+}
+
+type gltfMaterial struct {
+	Name                 string
+	PbrMetallicRoughness struct {
+		BaseColorFactor  []float64
+		BaseColorTexture *struct{ Index int }
+		MetallicFactor   *float64
+		RoughnessFactor  *float64
+	}
+	EmissiveFactor []float64
+	AlphaMode      string
+}
+
+type gltfImage struct {
+	Uri        string
+	BufferView *int
+	MimeType   string
+}
+
+type gltfLoader struct {
+	ctx      context.Context
+	filename string
+	doc      gltfDocument
+
+	buffers   [][]byte
+	materials []*Material
+	textures  map[int]*Texture // By image index, as images are often shared
+
+	builder        meshBuilder
+	vertexAmount   int
+	unusedVertices int
+}
+
+func init() {
+	RegisterMeshFormat("glTF", []string{".gltf", ".glb"}, "glTF", ParseGltf)
+}
+
+// ParseGltf reads a .gltf (with external or data URI buffers) or .glb model into a mesh, with
+// the meshes of every node of the scene placed by the node transforms. External files are found
+// relative to filename.
+func ParseGltf(ctx context.Context, r io.Reader, filename string) (*Mesh, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	l := gltfLoader{ctx: ctx, filename: filename, textures: make(map[int]*Texture)}
+
+	jsonData := data
+	var binChunk []byte
+	if len(data) >= 4 && binary.LittleEndian.Uint32(data) == GLB_MAGIC {
+		jsonData, binChunk, err = l.splitGlb(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := json.Unmarshal(jsonData, &l.doc); err != nil {
+		return nil, l.jsonError(jsonData, err)
+	}
+
+	for _, extension := range l.doc.ExtensionsRequired {
+		if !gltfSupportedExtensions[extension] {
+			return nil, &ParseError{File: filename, Token: extension, Msg: "unsupported required extension"}
+		}
+	}
+
+	if err := l.loadBuffers(binChunk); err != nil {
+		return nil, err
+	}
+
+	startPhase(ctx, LOAD_TEXTURES, len(l.doc.Materials))
+	for i, m := range l.doc.Materials {
+		if err := reportProgress(ctx, i); err != nil {
+			return nil, err
+		}
+
+		material, err := l.loadMaterial(m)
+		if err != nil {
+			return nil, err
+		}
+		l.materials = append(l.materials, material)
+	}
+
+	startPhase(ctx, LOAD_FACES, 0)
+	for _, node := range l.rootNodes() {
+		if err := l.addNode(node, identityMatrix(), 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.builder.triangles == 0 {
+		return nil, &ParseError{File: filename, Msg: "no triangle meshes found"}
+	}
+
+	mesh := l.builder.build()
+	mesh.faceAmount = l.builder.triangles
+	mesh.triangleAmount = l.builder.triangles
+	mesh.vertexAmount = l.vertexAmount
+	mesh.unusedVertices = l.unusedVertices
+
+	return mesh, nil
+}
+
+func (l *gltfLoader) errorf(format string, args ...interface{}) *ParseError {
+	return &ParseError{File: l.filename, Msg: fmt.Sprintf(format, args...)}
+}
+
+// splitGlb returns the JSON and binary chunks of a .glb file.
+func (l *gltfLoader) splitGlb(data []byte) ([]byte, []byte, error) {
+	if len(data) < 12 {
+		return nil, nil, l.errorf("truncated GLB header")
+	}
+	if version := binary.LittleEndian.Uint32(data[4:]); version != 2 {
+		return nil, nil, l.errorf("unsupported GLB version %d", version)
+	}
+
+	var jsonChunk, binChunk []byte
+	for offset := 12; offset+8 <= len(data); {
+		length := int(binary.LittleEndian.Uint32(data[offset:]))
+		chunkType := binary.LittleEndian.Uint32(data[offset+4:])
+		offset += 8
+
+		if length < 0 || offset+length > len(data) {
+			return nil, nil, l.errorf("truncated GLB chunk")
+		}
+
+		switch chunkType {
+		case GLB_CHUNK_JSON:
+			jsonChunk = data[offset : offset+length]
+		case GLB_CHUNK_BIN:
+			if binChunk == nil {
+				binChunk = data[offset : offset+length]
+			}
+		}
+		offset += length
+	}
+
+	if jsonChunk == nil {
+		return nil, nil, l.errorf("missing GLB JSON chunk")
+	}
+
+	return jsonChunk, binChunk, nil
+}
+
+// jsonError reports JSON errors with the line and column where they were found.
+func (l *gltfLoader) jsonError(data []byte, err error) error {
+	offset := int64(-1)
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+	} else if errors.As(err, &typeErr) {
+		offset = typeErr.Offset
+	}
+
+	parseErr := &ParseError{File: l.filename, Msg: "invalid glTF JSON", Err: err}
+	if offset >= 0 && offset <= int64(len(data)) {
+		before := data[:offset]
+		parseErr.Line = bytes.Count(before, []byte("\n")) + 1
+		parseErr.Col = len(before) - bytes.LastIndexByte(before, '\n')
+	}
+
+	return parseErr
+}
+
+// readUri returns the contents of a data URI or of a file relative to the model.
+func (l *gltfLoader) readUri(uri string) ([]byte, error) {
+	if strings.HasPrefix(uri, "data:") {
+		comma := strings.Index(uri, ",")
+		if comma < 0 {
+			return nil, errors.New("malformed data URI")
+		}
+		if strings.HasSuffix(uri[:comma], ";base64") {
+			return base64.StdEncoding.DecodeString(uri[comma+1:])
+		}
+		data, err := url.PathUnescape(uri[comma+1:])
+		return []byte(data), err
+	}
+
+	path, err := url.PathUnescape(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(filepath.Join(filepath.Dir(l.filename), filepath.FromSlash(path)))
+}
+
+func (l *gltfLoader) loadBuffers(binChunk []byte) error {
+	for i, buffer := range l.doc.Buffers {
+		// The first buffer of .glb files has no URI and uses the binary chunk
+		if buffer.Uri == "" {
+			if i != 0 || binChunk == nil {
+				return l.errorf("buffer %d has no data", i)
+			}
+			l.buffers = append(l.buffers, binChunk)
+			continue
+		}
+
+		data, err := l.readUri(buffer.Uri)
+		if err != nil {
+			return &ParseError{File: l.filename, Token: shortUri(buffer.Uri), Msg: "cannot load buffer", Err: err}
+		}
+		l.buffers = append(l.buffers, data)
+	}
+
+	return nil
+}
+
+// shortUri keeps error messages readable when the URI embeds a whole file.
+func shortUri(uri string) string {
+	if strings.HasPrefix(uri, "data:") && len(uri) > 32 {
+		return uri[:32] + "..."
+	}
+
+	return uri
+}
+
+// bufferView returns the bytes of a buffer view and its stride (0 when tightly packed).
+func (l *gltfLoader) bufferView(idx int) ([]byte, int, error) {
+	if idx < 0 || idx >= len(l.doc.BufferViews) {
+		return nil, 0, l.errorf("buffer view %d not found", idx)
+	}
+
+	view := l.doc.BufferViews[idx]
+	if view.Buffer < 0 || view.Buffer >= len(l.buffers) {
+		return nil, 0, l.errorf("buffer %d not found", view.Buffer)
+	}
+
+	buffer := l.buffers[view.Buffer]
+	if view.ByteOffset < 0 || view.ByteLength < 0 || view.ByteOffset+view.ByteLength > len(buffer) {
+		return nil, 0, l.errorf("buffer view %d is out of its buffer bounds", idx)
+	}
+
+	return buffer[view.ByteOffset : view.ByteOffset+view.ByteLength], view.ByteStride, nil
+}
+
+func readGltfComponent(data []byte, componentType int, normalized bool) float64 {
+	switch componentType {
+	case GLTF_BYTE:
+		value := float64(int8(data[0]))
+		if normalized {
+			return math.Max(value/127, -1)
+		}
+		return value
+	case GLTF_UNSIGNED_BYTE:
+		value := float64(data[0])
+		if normalized {
+			return value / 255
+		}
+		return value
+	case GLTF_SHORT:
+		value := float64(int16(binary.LittleEndian.Uint16(data)))
+		if normalized {
+			return math.Max(value/32767, -1)
+		}
+		return value
+	case GLTF_UNSIGNED_SHORT:
+		value := float64(binary.LittleEndian.Uint16(data))
+		if normalized {
+			return value / 65535
+		}
+		return value
+	case GLTF_UNSIGNED_INT:
+		return float64(binary.LittleEndian.Uint32(data))
+	default:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(data)))
+	}
+}
+
+// readAccessor returns the accessor elements as a flat slice of values, and the number of
+// components of each element.
+func (l *gltfLoader) readAccessor(idx int) ([]float64, int, error) {
+	if idx < 0 || idx >= len(l.doc.Accessors) {
+		return nil, 0, l.errorf("accessor %d not found", idx)
+	}
+
+	a := l.doc.Accessors[idx]
+	components, ok := gltfTypeComponents[a.Type]
+	if !ok {
+		return nil, 0, l.errorf("accessor %d has an invalid type '%s'", idx, a.Type)
+	}
+	size, ok := gltfComponentSizes[a.ComponentType]
+	if !ok {
+		return nil, 0, l.errorf("accessor %d has an invalid component type %d", idx, a.ComponentType)
+	}
+	if a.Count < 0 {
+		return nil, 0, l.errorf("accessor %d has a negative count", idx)
+	}
+
+	// Check the count against the data before allocating the values, as it comes from the file
+	var data []byte
+	stride := 0
+	if a.BufferView != nil {
+		var err error
+		data, stride, err = l.bufferView(*a.BufferView)
+		if err != nil {
+			return nil, 0, err
+		}
+		if stride == 0 {
+			stride = components * size
+		}
+
+		if a.ByteOffset < 0 || (a.Count > 0 && (a.ByteOffset+components*size > len(data) ||
+			a.Count-1 > (len(data)-a.ByteOffset-components*size)/stride)) {
+			return nil, 0, l.errorf("accessor %d is out of its buffer view bounds", idx)
+		}
+	} else if a.Count > MAX_GLTF_ZERO_ACCESSOR {
+		return nil, 0, l.errorf("accessor %d without a buffer view has too many elements (%d)", idx, a.Count)
+	}
+
+	// Accessors without a buffer view are all zeros (before applying the sparse values)
+	values := make([]float64, a.Count*components)
+
+	if a.BufferView != nil {
+		for i := 0; i < a.Count; i++ {
+			for c := 0; c < components; c++ {
+				offset := a.ByteOffset + i*stride + c*size
+				values[i*components+c] = readGltfComponent(data[offset:], a.ComponentType, a.Normalized)
+			}
+		}
+	}
+
+	if sparse := a.Sparse; sparse != nil {
+		indexData, _, err := l.bufferView(sparse.Indices.BufferView)
+		if err != nil {
+			return nil, 0, err
+		}
+		valueData, _, err := l.bufferView(sparse.Values.BufferView)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if sparse.Count < 0 || sparse.Indices.ByteOffset < 0 || sparse.Values.ByteOffset < 0 {
+			return nil, 0, l.errorf("sparse accessor %d has a negative count or offset", idx)
+		}
+
+		indexSize := gltfComponentSizes[sparse.Indices.ComponentType]
+		if indexSize == 0 || sparse.Indices.ByteOffset > len(indexData) || sparse.Values.ByteOffset > len(valueData) ||
+			sparse.Count > (len(indexData)-sparse.Indices.ByteOffset)/indexSize ||
+			sparse.Count > (len(valueData)-sparse.Values.ByteOffset)/(components*size) {
+			return nil, 0, l.errorf("sparse accessor %d is out of its buffer view bounds", idx)
+		}
+
+		for i := 0; i < sparse.Count; i++ {
+			target := int(readGltfComponent(indexData[sparse.Indices.ByteOffset+i*indexSize:], sparse.Indices.ComponentType, false))
+			if target >= a.Count {
+				return nil, 0, l.errorf("sparse accessor %d has an out of range index %d", idx, target)
+			}
+
+			for c := 0; c < components; c++ {
+				offset := sparse.Values.ByteOffset + (i*components+c)*size
+				values[target*components+c] = readGltfComponent(valueData[offset:], a.ComponentType, a.Normalized)
+			}
+		}
+	}
+
+	return values, components, nil
+}
+
+func (l *gltfLoader) loadImage(idx int) (*Texture, error) {
+	if texture, ok := l.textures[idx]; ok {
+		return texture, nil
+	}
+	if idx < 0 || idx >= len(l.doc.Images) {
+		return nil, l.errorf("image %d not found", idx)
+	}
+
+	img := l.doc.Images[idx]
+
+	var data []byte
+	var err error
+	if img.BufferView != nil {
+		data, _, err = l.bufferView(*img.BufferView)
+	} else {
+		data, err = l.readUri(img.Uri)
+	}
+
+	var texture *Texture
+	if err == nil {
+		texture, err = DecodeTexture(bytes.NewReader(data))
+	}
+	if err != nil {
+		token := shortUri(img.Uri)
+		if token == "" {
+			token = fmt.Sprintf("image %d", idx)
+		}
+		return nil, &ParseError{File: l.filename, Token: token, Msg: "cannot load texture", Err: err}
+	}
+
+	l.textures[idx] = texture
+
+	return texture, nil
+}
+
+// applySampler returns the texture with the wrap modes of the sampler, if any.
+func (l *gltfLoader) applySampler(texture *Texture, sampler *int) *Texture {
+	if sampler == nil || *sampler < 0 || *sampler >= len(l.doc.Samplers) {
+		return texture
+	}
+
+	wrapU, wrapV := WRAP_REPEAT, WRAP_REPEAT
+	if wrapS := l.doc.Samplers[*sampler].WrapS; wrapS != nil {
+		wrapU = gltfWrapModes[*wrapS]
+	}
+	if wrapT := l.doc.Samplers[*sampler].WrapT; wrapT != nil {
+		wrapV = gltfWrapModes[*wrapT]
+	}
+
+	return texture.WithWrap(wrapU, wrapV)
+}
+
+// loadMaterial converts a metallic-roughness material into the viewer's Blinn-Phong one.
+func (l *gltfLoader) loadMaterial(m gltfMaterial) (*Material, error) {
+	material := NewMaterial(m.Name)
+	pbr := m.PbrMetallicRoughness
+
+	baseColor := [4]float64{1, 1, 1, 1}
+	copy(baseColor[:], pbr.BaseColorFactor)
+	material.diffuse = NewColorVector(baseColor[0], baseColor[1], baseColor[2])
+	if m.AlphaMode == "BLEND" || m.AlphaMode == "MASK" {
+		material.opacity = baseColor[3]
+	}
+
+	if pbr.BaseColorTexture != nil {
+		textures := l.doc.Textures
+		idx := pbr.BaseColorTexture.Index
+		if idx < 0 || idx >= len(textures) {
+			return nil, l.errorf("texture %d not found", idx)
+		}
+		if textures[idx].Source != nil {
+			texture, err := l.loadImage(*textures[idx].Source)
+			if err != nil {
+				return nil, err
+			}
+			material.diffuseMap = l.applySampler(texture, textures[idx].Sampler)
+		}
+	}
+
+	metallic, roughness := 1.0, 1.0
+	if pbr.MetallicFactor != nil {
+		metallic = *pbr.MetallicFactor
+	}
+	if pbr.RoughnessFactor != nil {
+		roughness = *pbr.RoughnessFactor
+	}
+
+	// Metals tint their highlights with the base color, and rough surfaces have wide and dim ones.
+	// The diffuse color is kept for metals, as there is no environment for them to reflect.
+	material.specular = NewColorVector(0.04, 0.04, 0.04).Scale(1 - metallic).Add(material.diffuse.Scale(metallic)).Scale(1 - roughness)
+	material.shininess = math.Max(1, 2/math.Pow(math.Max(roughness, 0.01), 4)-2)
+
+	emissive := [3]float64{}
+	copy(emissive[:], m.EmissiveFactor)
+	material.emissive = NewColorVector(emissive[0], emissive[1], emissive[2])
+
+	return material, nil
+}
+
+// rootNodes returns the nodes of the default scene, or every node without a parent if there are
+// no scenes.
+func (l *gltfLoader) rootNodes() []int {
+	if len(l.doc.Scenes) > 0 {
+		scene := 0
+		if l.doc.Scene != nil && *l.doc.Scene >= 0 && *l.doc.Scene < len(l.doc.Scenes) {
+			scene = *l.doc.Scene
+		}
+		return l.doc.Scenes[scene].Nodes
+	}
+
+	isChild := make([]bool, len(l.doc.Nodes))
+	for _, node := range l.doc.Nodes {
+		for _, child := range node.Children {
+			if child >= 0 && child < len(isChild) {
+				isChild[child] = true
+			}
+		}
+	}
+
+	roots := []int{}
+	for i := range l.doc.Nodes {
+		if !isChild[i] {
+			roots = append(roots, i)
+		}
+	}
+
+	return roots
+}
+
+// gltfNodeMatrix returns the local transform of the node, as a row vector matrix like the rest
+// of the viewer's ones.
+func gltfNodeMatrix(node gltfNode) mat44 {
+	mat := identityMatrix()
+
+	if len(node.Matrix) == 16 {
+		// Transposing the column vector matrix cancels out its column major order
+		for i := 0; i < 16; i++ {
+			mat.m[i/4][i%4] = node.Matrix[i]
+		}
+		return mat
+	}
+
+	s := [3]float64{1, 1, 1}
+	copy(s[:], node.Scale)
+	q := [4]float64{0, 0, 0, 1}
+	copy(q[:], node.Rotation)
+	t := [3]float64{}
+	copy(t[:], node.Translation)
+
+	rotation := Quaternion{q[0], q[1], q[2], q[3]}.matrix()
+
+	// Scale, then rotate, then translate
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			mat.m[i][j] = s[i] * rotation.m[i][j]
+		}
+		mat.m[3][i] = t[i]
+	}
+
+	return mat
+}
+
+func (l *gltfLoader) addNode(idx int, parent mat44, depth int) error {
+	if idx < 0 || idx >= len(l.doc.Nodes) {
+		return l.errorf("node %d not found", idx)
+	}
+	if depth > len(l.doc.Nodes) {
+		return l.errorf("node %d is part of a cycle", idx)
+	}
+
+	node := l.doc.Nodes[idx]
+	world := gltfNodeMatrix(node).multiplyMatrix(parent)
+
+	if node.Mesh != nil {
+		if *node.Mesh < 0 || *node.Mesh >= len(l.doc.Meshes) {
+			return l.errorf("mesh %d not found", *node.Mesh)
+		}
+
+		for _, primitive := range l.doc.Meshes[*node.Mesh].Primitives {
+			if err := l.addPrimitive(primitive, world); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, child := range node.Children {
+		if err := l.addNode(child, world, depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// primitiveTriangles returns the vertex indices of the triangles of a primitive.
+func primitiveTriangles(mode int, indices []int) [][3]int {
+	tris := [][3]int{}
+
+	switch mode {
+	case GLTF_MODE_TRIANGLES:
+		for i := 0; i+2 < len(indices); i += 3 {
+			tris = append(tris, [3]int{indices[i], indices[i+1], indices[i+2]})
+		}
+	case GLTF_MODE_TRIANGLE_STRIP:
+		// Every other triangle is flipped to keep the winding order
+		for i := 0; i+2 < len(indices); i++ {
+			if i%2 == 0 {
+				tris = append(tris, [3]int{indices[i], indices[i+1], indices[i+2]})
+			} else {
+				tris = append(tris, [3]int{indices[i+1], indices[i], indices[i+2]})
+			}
+		}
+	case GLTF_MODE_TRIANGLE_FAN:
+		for i := 1; i+1 < len(indices); i++ {
+			tris = append(tris, [3]int{indices[0], indices[i], indices[i+1]})
+		}
+	}
+
+	return tris
+}
+
+func (l *gltfLoader) addPrimitive(p gltfPrimitive, world mat44) error {
+	if err := reportProgress(l.ctx, l.builder.triangles); err != nil {
+		return err
+	}
+
+	mode := GLTF_MODE_TRIANGLES
+	if p.Mode != nil {
+		mode = *p.Mode
+	}
+	if mode != GLTF_MODE_TRIANGLES && mode != GLTF_MODE_TRIANGLE_STRIP && mode != GLTF_MODE_TRIANGLE_FAN {
+		return nil
+	}
+
+	positionIdx, ok := p.Attributes["POSITION"]
+	if !ok {
+		return l.errorf("primitive without POSITION attribute")
+	}
+	positions, components, err := l.readAccessor(positionIdx)
+	if err != nil {
+		return err
+	}
+	if components != 3 {
+		return l.errorf("POSITION accessor %d is not a VEC3", positionIdx)
+	}
+	count := len(positions) / 3
+
+	// Optional attributes, nil when missing
+	readAttribute := func(name string, minComponents int) ([]float64, int, error) {
+		idx, ok := p.Attributes[name]
+		if !ok {
+			return nil, 0, nil
+		}
+
+		values, components, err := l.readAccessor(idx)
+		if err != nil {
+			return nil, 0, err
+		}
+		if components < minComponents || len(values)/components < count {
+			return nil, 0, l.errorf("%s accessor %d does not match the POSITION one", name, idx)
+		}
+
+		return values, components, nil
+	}
+
+	normals, _, err := readAttribute("NORMAL", 3)
+	if err != nil {
+		return err
+	}
+	texCoords, _, err := readAttribute("TEXCOORD_0", 2)
+	if err != nil {
+		return err
+	}
+	colors, colorComponents, err := readAttribute("COLOR_0", 3)
+	if err != nil {
+		return err
+	}
+
+	indices := make([]int, count)
+	if p.Indices != nil {
+		values, _, err := l.readAccessor(*p.Indices)
+		if err != nil {
+			return err
+		}
+
+		indices = make([]int, len(values))
+		for i, value := range values {
+			indices[i] = int(value)
+			if indices[i] >= count {
+				return l.errorf("index %d is out of range (%d vertices)", indices[i], count)
+			}
+		}
+	} else {
+		for i := range indices {
+			indices[i] = i
+		}
+	}
+
+	material := defaultMaterial
+	if p.Material != nil {
+		if *p.Material < 0 || *p.Material >= len(l.materials) {
+			return l.errorf("material %d not found", *p.Material)
+		}
+		material = l.materials[*p.Material]
+	}
+
+	// Normals are transformed with the inverse transpose, so non uniform scales keep them
+	// perpendicular to the surface. Mirroring transforms also flip the winding order.
+	normalMatrix := world.normalMatrix()
+	mirrored := world.determinant3() < 0
+
+	vertex := func(i int) Vector4 {
+		v := world.multiplyVector(NewVector4(positions[i*3], positions[i*3+1], positions[i*3+2], 1))
+		v.originalZ = -1
+
+		if normals != nil {
+			v.normVec = normalMatrix.multiplyNormal(NewNormalVector(normals[i*3], normals[i*3+1], normals[i*3+2])).Normalise()
+		}
+		if texCoords != nil {
+			// glTF has the texture origin at the top left corner, the viewer at the bottom left one
+			v.texVec = NewTexVector(texCoords[i*2], 1-texCoords[i*2+1], 0)
+		}
+		if colors != nil {
+			c := colors[i*colorComponents:]
+			v.color = NewColorVector(c[0], c[1], c[2])
+		}
+
+		return v
+	}
+
+	// The vertices are shared as indexed by the primitive, except without normals, where they get
+	// the flat normal of each face
+	meshIndices := make([]int32, count)
+	for i := range meshIndices {
+		meshIndices[i] = -1
+	}
+	unused := count
+	meshVertex := func(i int) int32 {
+		if meshIndices[i] < 0 {
+			meshIndices[i] = l.builder.addVertex(vertex(i), 0)
+			unused--
+		} else if normals == nil {
+			return l.builder.addVertex(vertex(i), 0)
+		}
+		return meshIndices[i]
+	}
+
+	for _, idx := range primitiveTriangles(mode, indices) {
+		if mirrored {
+			idx[1], idx[2] = idx[2], idx[1]
+		}
+
+		l.builder.addTriangle(material, colors != nil, meshVertex(idx[0]), meshVertex(idx[1]), meshVertex(idx[2]))
+	}
+
+	l.vertexAmount += count
+	l.unusedVertices += unused
+
+	return nil
+}
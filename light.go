@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type LightType int
+
+const (
+	LIGHT_DIRECTIONAL LightType = iota // Parallel rays, like the sun
+	LIGHT_POINT                        // Shines in every direction from its position, fading with the distance
+	LIGHT_SPOT                         // A point light limited to a cone around its direction
+)
+
+var lightTypeNames = []string{"Directional", "Point", "Spot"}
+
+func (t LightType) String() string {
+	return lightTypeNames[t]
+}
+
+func ParseLightType(name string) (LightType, error) {
+	for i, typeName := range lightTypeNames {
+		if strings.EqualFold(name, typeName) {
+			return LightType(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown light type '%s'", name)
+}
+
+const (
+	MAX_LIGHTS   int     = 8
+	SPOT_FEATHER float64 = 0.2 // Fraction of the spot cone angle over which its edge fades out
+)
+
+// Light illuminates the model. Point and spot lights are placed against their direction from
+// the center of the model, at a distance relative to its size, so they suit models of any scale.
+type Light struct {
+	lightType LightType
+	color     ColorVector
+	intensity float64
+
+	fixedToCamera bool    // Defined in view space, so it moves with the camera, instead of model space
+	direction     Vector4 // Where the light shines towards, normalised
+	castShadows   bool    // Only directional and spot lights cast shadows, when the renderer draws them
+
+	distance    float64 // Of point and spot lights from the center of the model, in model radii
+	attenuation float64 // Quadratic falloff of point and spot lights, per squared model radius
+	coneAngle   float64 // Half angle of the cone of spot lights, in radians
+}
+
+// NewLight creates a white light of the given type shining from above and behind the camera.
+func NewLight(lightType LightType) Light {
+	l := Light{
+		lightType: lightType,
+		color:     NewColorVector(1, 1, 1),
+		intensity: 1,
+
+		fixedToCamera: true,
+		castShadows:   true,
+
+		distance:    3,
+		attenuation: 0.1,
+		coneAngle:   degToRad(30),
+	}
+	l.SetAngles(0, degToRad(45))
+
+	return l
+}
+
+// Angles returns where the light comes from: its azimuth, positive to the right of the view (or of
+// the model front), and its elevation above the horizon, in radians.
+func (l *Light) Angles() (float64, float64) {
+	return math.Atan2(l.direction.x, l.direction.z), math.Asin(math.Max(-1, math.Min(1, -l.direction.y)))
+}
+
+func (l *Light) SetAngles(azimuth, elevation float64) {
+	l.direction = NewVector4(math.Sin(azimuth)*math.Cos(elevation), -math.Sin(elevation), math.Cos(azimuth)*math.Cos(elevation), 0)
+}
+
+// DefaultLights returns the lights of a new renderer: a single directional light following the
+// camera.
+func DefaultLights() []Light {
+	return []Light{NewLight(LIGHT_DIRECTIONAL)}
+}
+
+// A light transformed into view space for the current frame
+type viewLight struct {
+	lightType LightType
+	radiance  ColorVector // Color times intensity
+
+	direction   Vector4 // Where the light shines towards
+	position    Vector4
+	attenuation float64 // Per squared view space unit
+
+	cosOuter, cosInner float64 // Of the spot cone angle, and of the angle where its edge starts fading
+
+	shadow *shadowMap // Nil when the light casts no shadows
+}
+
+// prepareLights transforms the lights into view space for the frame, placing the point and spot
+// lights around the mesh seen through the world matrix.
+func (r *Renderer) prepareLights(mesh *Mesh, worldMatrix mat44) {
+	center, radius := mesh.boundingSphere()
+	center = worldMatrix.multiplyVector(center)
+
+	r.viewLights = r.viewLights[:0]
+	for _, light := range r.lights {
+		direction := light.direction
+		if !light.fixedToCamera {
+			n := worldMatrix.multiplyNormal(NewNormalVector(direction.x, direction.y, direction.z))
+			direction = NewVector4(n.x, n.y, n.z, 0)
+		}
+		direction = direction.Normalise()
+
+		l := viewLight{
+			lightType:   light.lightType,
+			radiance:    light.color.Scale(light.intensity),
+			direction:   direction,
+			position:    center.Sub(direction.Mul(light.distance * radius)),
+			attenuation: light.attenuation / (radius * radius),
+			cosOuter:    math.Cos(light.coneAngle),
+			cosInner:    math.Cos(light.coneAngle * (1 - SPOT_FEATHER)),
+		}
+		r.viewLights = append(r.viewLights, l)
+	}
+}
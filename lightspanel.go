@@ -0,0 +1,458 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"3d-viewer/ui"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const (
+	LIGHTS_PANEL_X     int32 = 20 // Left edge of the panel content
+	LIGHTS_PANEL_Y     int32 = 55 // Top of its first row
+	LIGHTS_PANEL_WIDTH int32 = 260
+	LIGHTS_PANEL_ROW   int32 = 26 // Height of each row
+	LIGHTS_PANEL_ROWS  int32 = 16
+)
+
+// Colors the light color button cycles through
+var LIGHT_COLORS = []ColorVector{
+	{1, 1, 1},
+	{1, 0.85, 0.6},
+	{0.6, 0.8, 1},
+	{1, 0.3, 0.3},
+	{0.3, 1, 0.3},
+	{0.3, 0.5, 1},
+}
+
+// Steps and limits of the light settings changed by the panel
+const (
+	LIGHT_INTENSITY_STEP   float64 = 0.1
+	MAX_LIGHT_INTENSITY    float64 = 5
+	LIGHT_ANGLE_STEP       float64 = 15 // Degrees, of the azimuth and elevation
+	LIGHT_DISTANCE_STEP    float64 = 0.5
+	MIN_LIGHT_DISTANCE     float64 = 0.5
+	MAX_LIGHT_DISTANCE     float64 = 20
+	LIGHT_ATTENUATION_STEP float64 = 0.05
+	MAX_LIGHT_ATTENUATION  float64 = 2
+	LIGHT_CONE_STEP        float64 = 5 // Degrees
+	MIN_LIGHT_CONE         float64 = 5
+	MAX_LIGHT_CONE         float64 = 90
+	AMBIENT_LIGHT_STEP     float64 = 0.05
+	SHADOW_BIAS_STEP       float64 = 0.25 // Shadow map texels
+	MAX_SHADOW_BIAS        float64 = 10
+	SSAO_RADIUS_STEP       float64 = 0.02 // Model radii
+	MIN_SSAO_RADIUS        float64 = 0.02
+	MAX_SSAO_RADIUS        float64 = 0.5
+	MIN_SSAO_SAMPLES       int     = 4 // Doubled or halved by each step
+	MAX_SSAO_SAMPLES       int     = 64
+	MAX_SSAO_BLUR          int     = 4
+)
+
+var (
+	lights       = DefaultLights()
+	ambientLight = AMBIENT_LIGHT
+	shadowBias   = DEFAULT_SHADOW_BIAS
+
+	occlusionRadius  = DEFAULT_SSAO_RADIUS
+	occlusionSamples = DEFAULT_SSAO_SAMPLES
+	occlusionBlur    = DEFAULT_SSAO_BLUR
+	selectedLight    int
+
+	btnLights  ui.Button
+	lblLights  ui.Label
+	showLights bool
+	cbLights   ui.ContentBlock
+
+	btnLightsPrev     ui.Button
+	lblLightsPrev     ui.Label
+	btnLightsNext     ui.Button
+	lblLightsNext     ui.Label
+	lblLightsSelected ui.Label
+	btnLightsAdd      ui.Button
+	lblLightsAdd      ui.Label
+	btnLightsRemove   ui.Button
+	lblLightsRemove   ui.Label
+
+	lblLightsTypeCaption    ui.Label
+	btnLightsType           ui.Button
+	lblLightsType           ui.Label
+	lblLightsFixedCaption   ui.Label
+	btnLightsFixed          ui.Button
+	lblLightsFixed          ui.Label
+	lblLightsColorCaption   ui.Label
+	btnLightsColor          ui.Button
+	lblLightsShadowsCaption ui.Label
+	btnLightsShadows        ui.Button
+	lblLightsShadows        ui.Label
+
+	stepLightIntensity   lightStepper
+	stepLightAzimuth     lightStepper
+	stepLightElevation   lightStepper
+	stepLightDistance    lightStepper
+	stepLightAttenuation lightStepper
+	stepLightCone        lightStepper
+	stepAmbientLight     lightStepper
+	stepShadowBias       lightStepper
+	stepOcclusionRadius  lightStepper
+	stepOcclusionSamples lightStepper
+	stepOcclusionBlur    lightStepper
+)
+
+// A panel row with a caption, and a value changed by its - and + buttons
+type lightStepper struct {
+	lblCaption ui.Label
+	lblValue   ui.Label
+	btnDec     ui.Button
+	lblDec     ui.Label
+	btnInc     ui.Button
+	lblInc     ui.Label
+}
+
+func newLightStepper(caption string) lightStepper {
+	return lightStepper{
+		lblCaption: ui.NewLabel(0, 0, caption, ui.NewMargin(0, 0), ui.TOP_LEFT, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall),
+		lblValue:   ui.NewLabel(0, 0, " ", ui.NewMargin(0, 0), ui.CENTER_CENTER, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall),
+		btnDec:     ui.NewButton(0, 0, 22, 22, ui.NewMargin(0, 0), ui.TOP_LEFT, 0xffffffff, 0xdddddddd, 0xbbbbbbbb),
+		lblDec:     ui.NewLabel(0, 0, "-", ui.NewMargin(0, 0), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall),
+		btnInc:     ui.NewButton(0, 0, 22, 22, ui.NewMargin(0, 0), ui.TOP_LEFT, 0xffffffff, 0xdddddddd, 0xbbbbbbbb),
+		lblInc:     ui.NewLabel(0, 0, "+", ui.NewMargin(0, 0), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall),
+	}
+}
+
+func (s *lightStepper) SetPosition(row int32) {
+	x, y := LIGHTS_PANEL_X, LIGHTS_PANEL_Y+LIGHTS_PANEL_ROW*row
+	s.lblCaption.SetPosition(x, y+4)
+	s.btnDec.SetPosition(x+170, y)
+	s.lblDec.SetPosition(x+170+11, y+4)
+	s.lblValue.SetPosition(x+214, y+4)
+	s.btnInc.SetPosition(x+238, y)
+	s.lblInc.SetPosition(x+238+11, y+4)
+}
+
+// UpdateAndGetStep returns -1 or 1 when the - or + button is pressed, and 0 otherwise.
+func (s *lightStepper) UpdateAndGetStep(x, y int32, pressing bool) float64 {
+	if pressed := s.btnDec.UpdateAndGetStatus(x, y, pressing); pressed {
+		return -1
+	}
+	if pressed := s.btnInc.UpdateAndGetStatus(x, y, pressing); pressed {
+		return 1
+	}
+
+	return 0
+}
+
+func (s *lightStepper) Draw(surface *sdl.Surface) {
+	s.lblCaption.Draw(surface)
+	s.btnDec.Draw(surface)
+	s.lblDec.Draw(surface)
+	s.lblValue.Draw(surface)
+	s.btnInc.Draw(surface)
+	s.lblInc.Draw(surface)
+}
+
+// createLightsPanel creates the lights button and panel, positioned by layoutLightsPanel.
+func createLightsPanel() {
+	btnLights = ui.NewButton(0, 0, 110, 25, ui.NewMargin(10, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	lblLights = ui.NewLabel(0, 0, "Lights", ui.NewMargin(10, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	cbLights = ui.NewContentBlock(0, 0, LIGHTS_PANEL_WIDTH, LIGHTS_PANEL_ROW*LIGHTS_PANEL_ROWS-4, ui.NewMargin(0, 0), ui.NewPadding(10, 5), ui.TOP_LEFT, 0x001a1a1a)
+
+	newButton := func(w int32) ui.Button {
+		return ui.NewButton(0, 0, w, 22, ui.NewMargin(0, 0), ui.TOP_LEFT, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	}
+	newLabel := func(text string) ui.Label {
+		return ui.NewLabel(0, 0, text, ui.NewMargin(0, 0), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	}
+	newCaption := func(text string) ui.Label {
+		return ui.NewLabel(0, 0, text, ui.NewMargin(0, 0), ui.TOP_LEFT, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
+	}
+
+	btnLightsPrev, lblLightsPrev = newButton(22), newLabel("<")
+	btnLightsNext, lblLightsNext = newButton(22), newLabel(">")
+	lblLightsSelected = ui.NewLabel(0, 0, " ", ui.NewMargin(0, 0), ui.CENTER_CENTER, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
+	btnLightsAdd, lblLightsAdd = newButton(44), newLabel("Add")
+	btnLightsRemove, lblLightsRemove = newButton(60), newLabel("Remove")
+
+	lblLightsTypeCaption, btnLightsType, lblLightsType = newCaption("Type"), newButton(90), newLabel(" ")
+	lblLightsFixedCaption, btnLightsFixed, lblLightsFixed = newCaption("Follows"), newButton(90), newLabel(" ")
+	lblLightsColorCaption, btnLightsColor = newCaption("Color"), newButton(22)
+	lblLightsShadowsCaption, btnLightsShadows, lblLightsShadows = newCaption("Casts shadows"), newButton(90), newLabel(" ")
+
+	stepLightIntensity = newLightStepper("Intensity")
+	stepLightAzimuth = newLightStepper("Azimuth (deg)")
+	stepLightElevation = newLightStepper("Elevation (deg)")
+	stepLightDistance = newLightStepper("Distance")
+	stepLightAttenuation = newLightStepper("Falloff")
+	stepLightCone = newLightStepper("Cone angle (deg)")
+	stepAmbientLight = newLightStepper("Ambient")
+	stepShadowBias = newLightStepper("Shadow bias")
+	stepOcclusionRadius = newLightStepper("Occlusion radius")
+	stepOcclusionSamples = newLightStepper("Occlusion samples")
+	stepOcclusionBlur = newLightStepper("Occlusion blur (px)")
+
+	updateLightsPanel()
+}
+
+func layoutLightsPanel() {
+	btnLights.SetPosition(110/2+20+240, 25/2+10)
+	lblLights.SetPosition(110/2+20+240, 25/2+10+3)
+	cbLights.SetPosition(LIGHTS_PANEL_X-10, LIGHTS_PANEL_Y-5)
+
+	x, y := LIGHTS_PANEL_X, LIGHTS_PANEL_Y
+	btnLightsPrev.SetPosition(x, y)
+	lblLightsPrev.SetPosition(x+11, y+4)
+	lblLightsSelected.SetPosition(x+65, y+4)
+	btnLightsNext.SetPosition(x+108, y)
+	lblLightsNext.SetPosition(x+108+11, y+4)
+	btnLightsAdd.SetPosition(x+150, y)
+	lblLightsAdd.SetPosition(x+150+22, y+4)
+	btnLightsRemove.SetPosition(x+200, y)
+	lblLightsRemove.SetPosition(x+200+30, y+4)
+
+	row := func(i int32) int32 { return y + LIGHTS_PANEL_ROW*i }
+	lblLightsTypeCaption.SetPosition(x, row(1)+4)
+	btnLightsType.SetPosition(x+170, row(1))
+	lblLightsType.SetPosition(x+170+45, row(1)+4)
+	lblLightsFixedCaption.SetPosition(x, row(2)+4)
+	btnLightsFixed.SetPosition(x+170, row(2))
+	lblLightsFixed.SetPosition(x+170+45, row(2)+4)
+	lblLightsColorCaption.SetPosition(x, row(3)+4)
+	btnLightsColor.SetPosition(x+238, row(3))
+
+	stepLightIntensity.SetPosition(4)
+	stepLightAzimuth.SetPosition(5)
+	stepLightElevation.SetPosition(6)
+	stepLightDistance.SetPosition(7)
+	stepLightAttenuation.SetPosition(8)
+	stepLightCone.SetPosition(9)
+	lblLightsShadowsCaption.SetPosition(x, row(10)+4)
+	btnLightsShadows.SetPosition(x+170, row(10))
+	lblLightsShadows.SetPosition(x+170+45, row(10)+4)
+	stepAmbientLight.SetPosition(11)
+	stepShadowBias.SetPosition(12)
+	stepOcclusionRadius.SetPosition(13)
+	stepOcclusionSamples.SetPosition(14)
+	stepOcclusionBlur.SetPosition(15)
+}
+
+// updateLightsPanelInput handles the clicks on the lights button and panel, editing the lights.
+func updateLightsPanelInput(x, y int32, pressing bool) {
+	if pressed := btnLights.UpdateAndGetStatus(x, y, pressing); pressed {
+		showLights = !showLights
+		if showLights {
+			showRecentFiles = false
+		}
+	}
+	if !showLights {
+		return
+	}
+
+	changed := false
+	if pressed := btnLightsPrev.UpdateAndGetStatus(x, y, pressing); pressed && len(lights) > 0 {
+		selectedLight = (selectedLight + len(lights) - 1) % len(lights)
+		changed = true
+	}
+	if pressed := btnLightsNext.UpdateAndGetStatus(x, y, pressing); pressed && len(lights) > 0 {
+		selectedLight = (selectedLight + 1) % len(lights)
+		changed = true
+	}
+	if pressed := btnLightsAdd.UpdateAndGetStatus(x, y, pressing); pressed && len(lights) < MAX_LIGHTS {
+		lights = append(lights, NewLight(LIGHT_POINT))
+		selectedLight = len(lights) - 1
+		changed = true
+	}
+	if pressed := btnLightsRemove.UpdateAndGetStatus(x, y, pressing); pressed && len(lights) > 0 {
+		lights = append(lights[:selectedLight], lights[selectedLight+1:]...)
+		selectedLight = max(0, min(selectedLight, len(lights)-1))
+		changed = true
+	}
+
+	if step := stepAmbientLight.UpdateAndGetStep(x, y, pressing); step != 0 {
+		ambientLight = math.Max(0, math.Min(1, ambientLight+step*AMBIENT_LIGHT_STEP))
+		changed = true
+	}
+	if step := stepShadowBias.UpdateAndGetStep(x, y, pressing); step != 0 {
+		shadowBias = math.Max(0, math.Min(MAX_SHADOW_BIAS, shadowBias+step*SHADOW_BIAS_STEP))
+		changed = true
+	}
+	if step := stepOcclusionRadius.UpdateAndGetStep(x, y, pressing); step != 0 {
+		occlusionRadius = math.Max(MIN_SSAO_RADIUS, math.Min(MAX_SSAO_RADIUS, occlusionRadius+step*SSAO_RADIUS_STEP))
+		changed = true
+	}
+	if step := stepOcclusionSamples.UpdateAndGetStep(x, y, pressing); step > 0 {
+		occlusionSamples = min(MAX_SSAO_SAMPLES, occlusionSamples*2)
+		changed = true
+	} else if step < 0 {
+		occlusionSamples = max(MIN_SSAO_SAMPLES, occlusionSamples/2)
+		changed = true
+	}
+	if step := stepOcclusionBlur.UpdateAndGetStep(x, y, pressing); step != 0 {
+		occlusionBlur = max(0, min(MAX_SSAO_BLUR, occlusionBlur+int(step)))
+		changed = true
+	}
+
+	if len(lights) > 0 {
+		l := &lights[selectedLight]
+
+		if pressed := btnLightsType.UpdateAndGetStatus(x, y, pressing); pressed {
+			l.lightType = (l.lightType + 1) % LightType(len(lightTypeNames))
+			changed = true
+		}
+		if pressed := btnLightsFixed.UpdateAndGetStatus(x, y, pressing); pressed {
+			l.fixedToCamera = !l.fixedToCamera
+			changed = true
+		}
+		if pressed := btnLightsColor.UpdateAndGetStatus(x, y, pressing); pressed {
+			next := 0
+			for i, c := range LIGHT_COLORS {
+				if c == l.color {
+					next = (i + 1) % len(LIGHT_COLORS)
+				}
+			}
+			l.color = LIGHT_COLORS[next]
+			changed = true
+		}
+
+		if pressed := btnLightsShadows.UpdateAndGetStatus(x, y, pressing); pressed && l.lightType != LIGHT_POINT {
+			l.castShadows = !l.castShadows
+			changed = true
+		}
+
+		if step := stepLightIntensity.UpdateAndGetStep(x, y, pressing); step != 0 {
+			l.intensity = math.Max(0, math.Min(MAX_LIGHT_INTENSITY, l.intensity+step*LIGHT_INTENSITY_STEP))
+			changed = true
+		}
+
+		azimuth, elevation := l.Angles()
+		if step := stepLightAzimuth.UpdateAndGetStep(x, y, pressing); step != 0 {
+			azimuth += degToRad(step * LIGHT_ANGLE_STEP)
+			l.SetAngles(azimuth, elevation)
+			changed = true
+		}
+		if step := stepLightElevation.UpdateAndGetStep(x, y, pressing); step != 0 {
+			elevation = math.Max(-math.Pi/2, math.Min(math.Pi/2, elevation+degToRad(step*LIGHT_ANGLE_STEP)))
+			l.SetAngles(azimuth, elevation)
+			changed = true
+		}
+
+		if l.lightType != LIGHT_DIRECTIONAL {
+			if step := stepLightDistance.UpdateAndGetStep(x, y, pressing); step != 0 {
+				l.distance = math.Max(MIN_LIGHT_DISTANCE, math.Min(MAX_LIGHT_DISTANCE, l.distance+step*LIGHT_DISTANCE_STEP))
+				changed = true
+			}
+			if step := stepLightAttenuation.UpdateAndGetStep(x, y, pressing); step != 0 {
+				l.attenuation = math.Max(0, math.Min(MAX_LIGHT_ATTENUATION, l.attenuation+step*LIGHT_ATTENUATION_STEP))
+				changed = true
+			}
+		}
+		if l.lightType == LIGHT_SPOT {
+			if step := stepLightCone.UpdateAndGetStep(x, y, pressing); step != 0 {
+				cone := math.Max(MIN_LIGHT_CONE, math.Min(MAX_LIGHT_CONE, math.Round(radToDeg(l.coneAngle))+step*LIGHT_CONE_STEP))
+				l.coneAngle = degToRad(cone)
+				changed = true
+			}
+		}
+	}
+
+	if changed {
+		updateLightsPanel()
+	}
+}
+
+// updateLightsPanel shows the settings of the selected light.
+func updateLightsPanel() {
+	stepAmbientLight.lblValue.SetText(fmt.Sprintf("%.2f", ambientLight))
+	stepShadowBias.lblValue.SetText(fmt.Sprintf("%.2f", shadowBias))
+	stepOcclusionRadius.lblValue.SetText(fmt.Sprintf("%.2f", occlusionRadius))
+	stepOcclusionSamples.lblValue.SetText(fmt.Sprintf("%d", occlusionSamples))
+	stepOcclusionBlur.lblValue.SetText(fmt.Sprintf("%d", occlusionBlur))
+
+	if len(lights) == 0 {
+		lblLightsSelected.SetText("No lights")
+		return
+	}
+
+	l := lights[selectedLight]
+	lblLightsSelected.SetText(fmt.Sprintf("Light %d/%d", selectedLight+1, len(lights)))
+	lblLightsType.SetText(l.lightType.String())
+	if l.fixedToCamera {
+		lblLightsFixed.SetText("Camera")
+	} else {
+		lblLightsFixed.SetText("Model")
+	}
+	if l.castShadows {
+		lblLightsShadows.SetText("On")
+	} else {
+		lblLightsShadows.SetText("Off")
+	}
+
+	c := l.color
+	surfaceColor := 0xff000000 | uint32(clampColorComponent(c.r))<<16 | uint32(clampColorComponent(c.g))<<8 | uint32(clampColorComponent(c.b)) // Surface is BGRA in memory
+	btnLightsColor.SetColors(surfaceColor, surfaceColor, surfaceColor)
+
+	azimuth, elevation := l.Angles()
+	stepLightIntensity.lblValue.SetText(fmt.Sprintf("%.1f", l.intensity))
+	stepLightAzimuth.lblValue.SetText(fmt.Sprintf("%.0f", radToDeg(azimuth)))
+	stepLightElevation.lblValue.SetText(fmt.Sprintf("%.0f", radToDeg(elevation)))
+	stepLightDistance.lblValue.SetText(fmt.Sprintf("%.1f", l.distance))
+	stepLightAttenuation.lblValue.SetText(fmt.Sprintf("%.2f", l.attenuation))
+	stepLightCone.lblValue.SetText(fmt.Sprintf("%.0f", radToDeg(l.coneAngle)))
+}
+
+func drawLightsPanel(surface *sdl.Surface) {
+	btnLights.Draw(surface)
+	lblLights.Draw(surface)
+	if !showLights {
+		return
+	}
+
+	cbLights.Draw(surface)
+	btnLightsPrev.Draw(surface)
+	lblLightsPrev.Draw(surface)
+	lblLightsSelected.Draw(surface)
+	btnLightsNext.Draw(surface)
+	lblLightsNext.Draw(surface)
+	btnLightsAdd.Draw(surface)
+	lblLightsAdd.Draw(surface)
+	stepAmbientLight.Draw(surface)
+	stepShadowBias.Draw(surface)
+	stepOcclusionRadius.Draw(surface)
+	stepOcclusionSamples.Draw(surface)
+	stepOcclusionBlur.Draw(surface)
+	if len(lights) == 0 {
+		return
+	}
+
+	btnLightsRemove.Draw(surface)
+	lblLightsRemove.Draw(surface)
+	lblLightsTypeCaption.Draw(surface)
+	btnLightsType.Draw(surface)
+	lblLightsType.Draw(surface)
+	lblLightsFixedCaption.Draw(surface)
+	btnLightsFixed.Draw(surface)
+	lblLightsFixed.Draw(surface)
+	lblLightsColorCaption.Draw(surface)
+	btnLightsColor.Draw(surface)
+	stepLightIntensity.Draw(surface)
+	stepLightAzimuth.Draw(surface)
+	stepLightElevation.Draw(surface)
+
+	// Only point and spot lights have a position, and only spot lights a cone
+	lightType := lights[selectedLight].lightType
+	if lightType != LIGHT_DIRECTIONAL {
+		stepLightDistance.Draw(surface)
+		stepLightAttenuation.Draw(surface)
+	}
+	if lightType == LIGHT_SPOT {
+		stepLightCone.Draw(surface)
+	}
+
+	// Point lights cast no shadows
+	if lightType != LIGHT_POINT {
+		lblLightsShadowsCaption.Draw(surface)
+		btnLightsShadows.Draw(surface)
+		lblLightsShadows.Draw(surface)
+	}
+}
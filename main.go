@@ -2,10 +2,13 @@ package main
 
 import (
 	"3d-viewer/ui"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"image/color"
+	"os"
 	"path/filepath"
-	"sync"
+	"strings"
 	"time"
 
 	"math"
@@ -16,10 +19,12 @@ import (
 )
 
 const (
-	SCREEN_WIDTH  int = 1280
-	SCREEN_HEIGHT int = 720
+	DEFAULT_SCREEN_WIDTH  int = 1280
+	DEFAULT_SCREEN_HEIGHT int = 720
+	MIN_SCREEN_WIDTH      int = 800 // Smallest window size fitting the UI
+	MIN_SCREEN_HEIGHT     int = 500
 
-	ASPECT_RATIO float64 = float64(SCREEN_HEIGHT) / float64(SCREEN_WIDTH)
+	MIN_RENDER_SCALE float64 = 0.01
 
 	DEFAULT_Y_OFFSET   float64 = -1
 	DEFAULT_Z_OFFSET   float64 = 10
@@ -29,44 +34,88 @@ const (
 	FAR_DISTANCE  float64 = 1000
 	FOV_DEGREES   float64 = 90
 
-	ROTATION_SPEED float64 = 2
-	POSITION_SPEED float64 = 10
+	KEY_PAN_SPEED float64 = 400 // Pixels per second the arrow keys pan the view
 
 	BG_COLOR uint32 = 0xff202020 // 0xAABBGGRR
+
+	LOAD_ERROR_DURATION time.Duration = 8 * time.Second // How long load errors stay on screen
+
+	RECENT_FILE_TEXT_LENGTH int = 42 // Characters of the recent file entries, to fit their buttons
+
+	LOADING_BAR_WIDTH int32 = 290
+
+	FILE_INFO_WIDTH        int32 = 150 // Width of the file info panel, and of its details when expanded
+	FILE_INFO_REPORT_WIDTH int32 = 180
 )
 
+// Line widths, point sizes and colors the wireframe buttons cycle through
 var (
-	SCALE_FACTOR                            int // To scale down the render resolution. For 1280x720, can be: x1, x2, x4, x8, x16
-	RENDER_WIDTH, RENDER_HEIGHT             int
-	RENDER_WIDTH_FLOAT, RENDER_HEIGHT_FLOAT float64
-	RENDER_WIDTH_HALF, RENDER_HEIGHT_HALF   float64
+	LINE_WIDTHS = []float64{1, 2, 3}
+	POINT_SIZES = []float64{1, 2, 3, 5, 8}
+	LINE_COLORS = []color.RGBA{
+		DEFAULT_LINE_COLOR,
+		{0, 0, 0, 255},
+		{255, 60, 60, 255},
+		{255, 204, 0, 255},
+		{0, 200, 255, 255},
+	}
 )
 
-var (
-	modelMesh *Mesh
+// Short names of the render modes, for their buttons
+var renderModeButtonNames = []string{"S", "W", "H", "SE"}
 
-	surface *sdl.Surface
+// Render scales of the resolution buttons, and their names
+var (
+	RENDER_SCALES          = []float64{1, 0.75, 0.5, 0.25, 0.125, 0.0625}
+	renderScaleButtonNames = []string{"x1", ".75", "/2", "/4", "/8", "/16"}
+)
 
-	renderBuffer []byte
-	screenBuffer []byte
+var (
+	SCREEN_WIDTH  int = DEFAULT_SCREEN_WIDTH // Current window size
+	SCREEN_HEIGHT int = DEFAULT_SCREEN_HEIGHT
 
-	depthBuffer       []float64
-	depthBufferLength int
+	RENDER_SCALE float64 // Render resolution relative to the window size, between MIN_RENDER_SCALE and 1
+)
 
-	flipNormals bool
+var (
+	modelMesh     *Mesh
+	modelFilename string
+	meshReport    MeshReport
+	loading       *loadJob // Model being loaded in the background, if any
+	recentFiles   []string
+
+	surface  *sdl.Surface
+	renderer *Renderer
+
+	flipNormals   bool
+	shadingMode   ShadingMode   = SHADING_PHONG
+	renderMode    RenderMode    = RENDER_SHADED
+	textureFilter TextureFilter = FILTER_TRILINEAR
+	showShadows   bool
+	showOcclusion bool
+
+	lineWidthIdx int
+	pointSizeIdx int
+	lineColorIdx int
 
 	tDelta float64 = 0
 
-	positionOffset, rotationTheta Vector4
+	camera Camera
 )
 
 var (
-	CTRL_PRESSED     bool = false
-	UP_PRESSED       bool = false
-	DOWN_PRESSED     bool = false
-	MOUSE_CLICK      bool = false
-	MOUSE_WHEEL_UP   bool = false
-	MOUSE_WHEEL_DOWN bool = false
+	UP_PRESSED         bool  = false
+	DOWN_PRESSED       bool  = false
+	LEFT_PRESSED       bool  = false
+	RIGHT_PRESSED      bool  = false
+	MOUSE_CLICK        bool  = false
+	MOUSE_MIDDLE       bool  = false
+	MOUSE_RIGHT        bool  = false
+	MOUSE_DOUBLE_CLICK bool  = false
+	MOUSE_WHEEL        int32 = 0     // Wheel steps of the frame, positive when scrolling up
+	MOUSE_ON_UI        bool  = false // Whether the left button press began over the UI, so it doesn't move the camera
+
+	FLY_KEYS_PRESSED = map[sdl.Keycode]bool{} // WASD and QE keys held, to move in fly mode
 )
 
 var (
@@ -77,38 +126,83 @@ var (
 	btnLoadMesh ui.Button
 	lblLoadMesh ui.Label
 
+	btnSaveMesh ui.Button
+	lblSaveMesh ui.Label
+
+	btnRecentFiles   ui.Button
+	lblRecentFiles   ui.Label
+	showRecentFiles  bool
+	cbRecentFiles    ui.ContentBlock
+	btnRecentFile    [MAX_RECENT_FILES]ui.Button
+	lblRecentFile    [MAX_RECENT_FILES]ui.Label
+	lblNoRecentFiles ui.Label
+
+	cbLoading        ui.ContentBlock
+	lblLoading       ui.Label
+	cbLoadingBar     ui.ContentBlock
+	cbLoadingBarFill ui.ContentBlock
+	btnLoadingCancel ui.Button
+	lblLoadingCancel ui.Label
+
+	cbLoadError   ui.ContentBlock
+	lblLoadError  ui.Label
+	loadErrorTime time.Time
+
 	cbFileInfo           ui.ContentBlock
 	lblFileInfoName      ui.Label
+	lblFileInfoFaces     ui.Label
 	lblFileInfoTriangles ui.Label
 	lblFileInfoVertices  ui.Label
+	btnFileInfoDetails   ui.Button
+	lblFileInfoDetails   ui.Label
+	showFileInfoReport   bool
+	lblFileInfoReport    [MESH_REPORT_LINES]ui.Label
 
 	cbFps  ui.ContentBlock
 	lblFps ui.Label
 
 	cbVisualTools             ui.ContentBlock
 	lblVisualToolsTitle       ui.Label
+	btnVisualToolsShading     ui.Button
+	lblVisualToolsShading     ui.Label
 	btnVisualToolsFlipNormals ui.Button
 	lblVisualToolsFlipNormals ui.Label
 	btnVisualToolsResetView   ui.Button
 	lblVisualToolsResetView   ui.Label
+	btnVisualToolsCamera      ui.Button
+	lblVisualToolsCamera      ui.Label
+	btnVisualToolsModes       [4]ui.Button
+	lblVisualToolsModes       [4]ui.Label
+	btnVisualToolsLineWidth   ui.Button
+	lblVisualToolsLineWidth   ui.Label
+	btnVisualToolsLineColor   ui.Button
+	btnVisualToolsFilter      ui.Button
+	lblVisualToolsFilter      ui.Label
+	btnVisualToolsShadows     ui.Button
+	lblVisualToolsShadows     ui.Label
+	btnVisualToolsOcclusion   ui.Button
+	lblVisualToolsOcclusion   ui.Label
 
 	cbResolution       ui.ContentBlock
 	lblResolutionTitle ui.Label
-	btnResolution1     ui.Button
-	lblResolution1     ui.Label
-	btnResolution2     ui.Button
-	lblResolution2     ui.Label
-	btnResolution4     ui.Button
-	lblResolution4     ui.Label
-	btnResolution8     ui.Button
-	lblResolution8     ui.Label
-	btnResolution16    ui.Button
-	lblResolution16    ui.Label
+	btnResolution      [6]ui.Button
+	lblResolution      [6]ui.Label
 
 	lblNoMeshLoaded ui.Label
 )
 
 func main() {
+	// Headless commands (e.g. 'render') run without creating any window
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	// SDL and window setup
 	if err := sdl.Init(sdl.INIT_EVERYTHING); err != nil {
 		zenity.Error(fmt.Sprintf("Error initializing SDL2.\n%s", err), zenity.Title("SDL2 error"), zenity.ErrorIcon)
@@ -116,13 +210,15 @@ func main() {
 	}
 	defer sdl.Quit()
 
-	window, err := sdl.CreateWindow("3D OBJ viewer - by keelus", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED, int32(SCREEN_WIDTH), int32(SCREEN_HEIGHT), sdl.WINDOW_SHOWN)
+	window, err := sdl.CreateWindow("3D OBJ viewer - by keelus", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED, int32(SCREEN_WIDTH), int32(SCREEN_HEIGHT), sdl.WINDOW_SHOWN|sdl.WINDOW_RESIZABLE)
 	if err != nil {
 		zenity.Error(fmt.Sprintf("Error creating the SDL2 window.\n%s", err), zenity.Title("SDL2 error"), zenity.ErrorIcon)
 		panic(err)
 	}
 	defer window.Destroy()
 
+	window.SetMinimumSize(int32(MIN_SCREEN_WIDTH), int32(MIN_SCREEN_HEIGHT))
+
 	// Window surface and depth buffer setup
 	surface, err = window.GetSurface()
 	if err != nil {
@@ -148,45 +244,19 @@ func main() {
 	fontSmall = ui.LoadFont("font.ttf", 14)
 	defer fontSmall.Close()
 
-	// Load custom UI elements
-	btnLoadMesh := ui.NewButton(110/2+20, 25/2+10, 110, 25, ui.NewMargin(10, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
-	lblLoadMesh := ui.NewLabel(110/2+20, 25/2+10+3, "Load file", ui.NewMargin(10, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
-
-	cbFileInfo = ui.NewContentBlock(1280, 0, 150, 50, ui.NewMargin(10, 10), ui.NewPadding(10, 13), ui.TOP_RIGHT, 0x001a1a1a)
-	lblFileInfoName = ui.NewLabel(1280, 5, " ", ui.NewMargin(20, 10), ui.TOP_RIGHT, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontRegular)
-	lblFileInfoTriangles = ui.NewLabel(1280, 30, " ", ui.NewMargin(20, 10), ui.TOP_RIGHT, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
-	lblFileInfoVertices = ui.NewLabel(1280, 50, " ", ui.NewMargin(20, 10), ui.TOP_RIGHT, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
-
-	cbFps = ui.NewContentBlock(int32(SCREEN_WIDTH)/2, 0, 85, 20, ui.NewMargin(0, 10), ui.NewPadding(0, 0), ui.TOP_CENTER, 0x00000000)
-	lblFps = ui.NewLabel(int32(SCREEN_WIDTH)/2, 0, " ", ui.NewMargin(0, 10), ui.TOP_CENTER, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
-
-	cbVisualTools = ui.NewContentBlock(1280, int32(SCREEN_HEIGHT), 110, 80, ui.NewMargin(10, 10), ui.NewPadding(10, 10), ui.BOTTOM_RIGHT, 0x001a1a1a)
-	lblVisualToolsTitle = ui.NewLabel(1280-65, int32(SCREEN_HEIGHT)-75, "Visual tools", ui.NewMargin(20, 10), ui.BOTTOM_CENTER, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
-	btnVisualToolsFlipNormals = ui.NewButton(1280-110/2, int32(SCREEN_HEIGHT)-65, 110, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
-	btnVisualToolsResetView = ui.NewButton(1280-110/2, int32(SCREEN_HEIGHT)-35, 110, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
-	lblVisualToolsFlipNormals = ui.NewLabel(1280-110/2, int32(SCREEN_HEIGHT)-60-2, "Flip normals", ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
-	lblVisualToolsResetView = ui.NewLabel(1280-110/2, int32(SCREEN_HEIGHT)-30-2, "Reset view", ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
-
-	lblNoMeshLoaded = ui.NewLabel(int32(SCREEN_WIDTH)/2, int32(SCREEN_HEIGHT)/2, "Load a 3D file to preview it (.obj supported)", ui.NewMargin(0, 0), ui.CENTER_CENTER, sdl.Color{R: 127, G: 127, B: 127, A: 255}, fontBig)
-
-	cbResolution = ui.NewContentBlock(0, int32(SCREEN_HEIGHT), 140, 50, ui.NewMargin(10, 10), ui.NewPadding(10, 10), ui.BOTTOM_LEFT, 0x001a1a1a)
-	lblResolutionTitle = ui.NewLabel(100, int32(SCREEN_HEIGHT)-45, "Resolution", ui.NewMargin(20, 10), ui.BOTTOM_CENTER, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
-
-	btnResolution1 = ui.NewButton(50, int32(SCREEN_HEIGHT)-35, 25, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
-	lblResolution1 = ui.NewLabel(100/2, int32(SCREEN_HEIGHT)-32, "x1", ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
-	btnResolution2 = ui.NewButton(80, int32(SCREEN_HEIGHT)-35, 25, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
-	lblResolution2 = ui.NewLabel(100/2+30, int32(SCREEN_HEIGHT)-32, "/2", ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
-	btnResolution4 = ui.NewButton(110, int32(SCREEN_HEIGHT)-35, 25, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
-	lblResolution4 = ui.NewLabel(100/2+60, int32(SCREEN_HEIGHT)-32, "/4", ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
-	btnResolution8 = ui.NewButton(140, int32(SCREEN_HEIGHT)-35, 25, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
-	lblResolution8 = ui.NewLabel(100/2+90, int32(SCREEN_HEIGHT)-32, "/8", ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
-	btnResolution16 = ui.NewButton(170, int32(SCREEN_HEIGHT)-35, 25, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
-	lblResolution16 = ui.NewLabel(100/2+120, int32(SCREEN_HEIGHT)-32, "/16", ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	createUI()
+	layoutUI()
+
+	recentFiles = LoadRecentFiles()
+	updateRecentFilesList()
+
+	// A model can be opened at startup, as in '3d-viewer model.obj'
+	if len(os.Args) > 1 {
+		LoadFile(os.Args[1])
+	}
 
 	// Initialize 3D and misc things
-	camera := Vector4{0, 0, 0, 1, -1, NewTexVector(0, 0, 0)}
 	flipNormals = false
-	matProj := projectionMatrix(ASPECT_RATIO, FOV_DEGREES, NEAR_DISTANCE, FAR_DISTANCE)
 
 	lastFrame := time.Now()
 	curX, curY, _ := sdl.GetMouseState()
@@ -194,26 +264,57 @@ func main() {
 	// Main loop
 	running := true
 	for running {
-		MOUSE_WHEEL_UP = false
-		MOUSE_WHEEL_DOWN = false
+		MOUSE_WHEEL = 0
+		MOUSE_DOUBLE_CLICK = false
 		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
 			switch event.(type) {
 			case *sdl.QuitEvent:
 				println("Quit")
 				running = false
 				break
+			case *sdl.DropEvent:
+				e := event.(*sdl.DropEvent)
+
+				if e.Type == sdl.DROPFILE {
+					LoadFile(e.File)
+				}
+				break
+			case *sdl.WindowEvent:
+				e := event.(*sdl.WindowEvent)
+
+				if e.Event == sdl.WINDOWEVENT_SIZE_CHANGED {
+					resizeWindow(window)
+				}
+				break
 			case *sdl.KeyboardEvent:
 				e := event.(*sdl.KeyboardEvent)
 
-				if e.Keysym.Sym == sdl.K_LCTRL {
-					CTRL_PRESSED = e.State == sdl.PRESSED
+				if e.Keysym.Sym == sdl.K_F11 && e.State == sdl.PRESSED && e.Repeat == 0 {
+					toggleFullscreen(window)
+				}
+				if e.Keysym.Sym == sdl.K_f && e.State == sdl.PRESSED && e.Repeat == 0 {
+					toggleFlyMode()
+				}
+				if e.Keysym.Sym == sdl.K_ESCAPE && e.State == sdl.PRESSED {
+					CancelLoad()
 				}
+				switch e.Keysym.Sym {
+				case sdl.K_w, sdl.K_a, sdl.K_s, sdl.K_d, sdl.K_q, sdl.K_e:
+					FLY_KEYS_PRESSED[e.Keysym.Sym] = e.State == sdl.PRESSED
+				}
+
 				if e.Keysym.Sym == sdl.K_UP {
 					UP_PRESSED = e.State == sdl.PRESSED
 				}
 				if e.Keysym.Sym == sdl.K_DOWN {
 					DOWN_PRESSED = e.State == sdl.PRESSED
 				}
+				if e.Keysym.Sym == sdl.K_LEFT {
+					LEFT_PRESSED = e.State == sdl.PRESSED
+				}
+				if e.Keysym.Sym == sdl.K_RIGHT {
+					RIGHT_PRESSED = e.State == sdl.PRESSED
+				}
 
 				break
 			case *sdl.MouseButtonEvent:
@@ -221,16 +322,20 @@ func main() {
 
 				if e.Button == sdl.BUTTON_LEFT {
 					MOUSE_CLICK = e.State == sdl.PRESSED
+					MOUSE_DOUBLE_CLICK = e.State == sdl.PRESSED && e.Clicks == 2
+					MOUSE_ON_UI = e.State == sdl.PRESSED && uiContains(e.X, e.Y)
+				}
+				if e.Button == sdl.BUTTON_MIDDLE {
+					MOUSE_MIDDLE = e.State == sdl.PRESSED
+				}
+				if e.Button == sdl.BUTTON_RIGHT {
+					MOUSE_RIGHT = e.State == sdl.PRESSED
 				}
 				break
 			case *sdl.MouseWheelEvent:
 				e := event.(*sdl.MouseWheelEvent)
 
-				if e.Y > 0 {
-					MOUSE_WHEEL_UP = true
-				} else if e.Y < 0 {
-					MOUSE_WHEEL_DOWN = true
-				}
+				MOUSE_WHEEL += e.Y
 
 			}
 		}
@@ -243,277 +348,512 @@ func main() {
 		lastFrame = time.Now()
 
 		// Update user mouse information
-		newX, newY, _ := sdl.GetMouseState()
-		diffX, diffY := curX-newX, curY-newY
-		curX, curY = newX, newY
+		prevX, prevY := curX, curY
+		curX, curY, _ = sdl.GetMouseState()
 
 		// Handle keyboard and mouse input
-		if CTRL_PRESSED && MOUSE_CLICK {
-			var MOUSE_ROTATION_SPEED float64 = 5
-			if diffX != 0 {
-				if diffX > 1 {
-					rotationTheta.y += MOUSE_ROTATION_SPEED * tDelta
-				} else if diffX < -1 {
-					rotationTheta.y -= MOUSE_ROTATION_SPEED * tDelta
-				}
-			}
-			if diffY != 0 {
-				if diffY < -1 {
-					rotationTheta.x += MOUSE_ROTATION_SPEED * tDelta
-				} else if diffY > 1 {
-					rotationTheta.x -= MOUSE_ROTATION_SPEED * tDelta
-				}
-			}
-		}
-
-		if MOUSE_WHEEL_UP {
-			positionOffset.z -= 5 * POSITION_SPEED * tDelta
-		} else if MOUSE_WHEEL_DOWN {
-			positionOffset.z += 5 * POSITION_SPEED * tDelta
+		if camera.fly {
+			updateFlyCamera()
+		} else {
+			updateOrbitCamera(prevX, prevY, curX, curY)
 		}
 
-		if UP_PRESSED {
-			positionOffset.y -= POSITION_SPEED * tDelta
-		}
-		if DOWN_PRESSED {
-			positionOffset.y += POSITION_SPEED * tDelta
+		if loading != nil {
+			if pressed := btnLoadingCancel.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+				CancelLoad()
+			}
 		}
+		updateLoading()
 
 		if pressed := btnLoadMesh.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
 			selected, _ := zenity.SelectFile(
-				zenity.Filename("/"),
-				zenity.FileFilters{
-					{
-						Name:     "OBJ files",
-						Patterns: []string{"*.obj"},
-						CaseFold: false,
-					},
-				})
+				zenity.Filename(fileDialogStart()),
+				meshFileFilters())
 			if selected != "" {
 				LoadFile(selected)
 				continue
 			}
 		}
 
-		if pressed := btnVisualToolsFlipNormals.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
-			flipNormals = !flipNormals
+		if modelMesh != nil {
+			if pressed := btnSaveMesh.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+				selected, _ := zenity.SelectFileSave(
+					zenity.Filename(modelFilename),
+					zenity.ConfirmOverwrite(),
+					meshSaveFilters())
+				if selected != "" {
+					SaveFile(selected)
+					continue
+				}
+			}
 		}
 
-		if pressed := btnVisualToolsResetView.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
-			ResetCameraView()
+		if pressed := btnRecentFiles.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+			showRecentFiles = !showRecentFiles
+			if showRecentFiles {
+				showLights = false
+			}
 		}
 
-		if pressed := btnResolution1.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
-			setScale(1)
-		}
-		if pressed := btnResolution2.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
-			setScale(2)
+		if showRecentFiles {
+			selected := ""
+			for i := range recentFiles {
+				if pressed := btnRecentFile[i].UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+					selected = recentFiles[i]
+				}
+			}
+			if selected != "" {
+				showRecentFiles = false
+				LoadFile(selected)
+				continue
+			}
 		}
-		if pressed := btnResolution4.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
-			setScale(4)
+
+		updateLightsPanelInput(curX, curY, MOUSE_CLICK)
+
+		if modelMesh != nil {
+			if pressed := btnFileInfoDetails.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+				showFileInfoReport = !showFileInfoReport
+				updateFileInfoPanel()
+			}
 		}
-		if pressed := btnResolution8.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
-			setScale(8)
+
+		if pressed := btnVisualToolsShading.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+			shadingMode = (shadingMode + 1) % ShadingMode(len(shadingModeNames))
+			lblVisualToolsShading.SetText(shadingMode.String() + " shading")
 		}
-		if pressed := btnResolution16.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
-			setScale(16)
+
+		for i := range btnVisualToolsModes {
+			if pressed := btnVisualToolsModes[i].UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+				renderMode = RenderMode(i)
+				updateRenderModeButtons()
+			}
 		}
 
-		// Main 3D code
-		if modelMesh != nil {
-			// Rotation, translation & world matrix
-			rotationMatrix := rotationMatrix(rotationTheta)
-			translationMatrix := MakeTranslation(positionOffset.x, positionOffset.y, positionOffset.z)
-			worldMatrix := rotationMatrix.multiplyMatrix(translationMatrix)
-
-			triangles := []Triangle{}
-			// Evaluate each triangle and save them if the normals face the camera. Handle clipping triangles.
-			for _, tri := range modelMesh.tris {
-				triTransformed := worldMatrix.multiplyTriangle(tri)
-				triTransformed.vecs[0].originalZ = triTransformed.vecs[0].z
-				triTransformed.vecs[1].originalZ = triTransformed.vecs[1].z
-				triTransformed.vecs[2].originalZ = triTransformed.vecs[2].z
-
-				// Calculate the normal of the triangle face
-				line1 := triTransformed.vecs[1].Sub(triTransformed.vecs[0])
-				line2 := triTransformed.vecs[2].Sub(triTransformed.vecs[0])
-				normal := line1.CrossProduct(line2).Normalise()
-
-				cameraRay := triTransformed.vecs[0].Sub(camera)
-
-				if (normal.Dot(cameraRay) < 0 && !flipNormals) || (normal.Dot(cameraRay) > 0 && flipNormals) {
-					// Simple illumination via light direction
-					lightDirection := Vector4{0, 1, -1, 1, -1, NewTexVector(0, 0, 0)}.Normalise()
-					ilumination := math.Max(0.1, lightDirection.Dot(normal))
-
-					// Transform and project triangles
-					clipped := ClipAgainstPlane(Vector4{0, 0, 0.1, 1, -1, NewTexVector(0, 0, 0)}, Vector4{0, 0, 1, 1, -1, NewTexVector(0, 0, 0)}, triTransformed)
-					for n := 0; n < len(clipped); n++ {
-						// Project triangles to 2D
-						triProjected := matProj.multiplyTriangle(clipped[n])
-						triProjected.ilum = ilumination
-						triProjected.tex = tri.tex
-
-						// Apply depth
-						triProjected.vecs[0].texVec.u /= triProjected.vecs[0].w
-						triProjected.vecs[1].texVec.u /= triProjected.vecs[1].w
-						triProjected.vecs[2].texVec.u /= triProjected.vecs[2].w
-						triProjected.vecs[0].texVec.v /= triProjected.vecs[0].w
-						triProjected.vecs[1].texVec.v /= triProjected.vecs[1].w
-						triProjected.vecs[2].texVec.v /= triProjected.vecs[2].w
-						triProjected.vecs[0].texVec.w = 1 / triProjected.vecs[0].w
-						triProjected.vecs[1].texVec.w = 1 / triProjected.vecs[1].w
-						triProjected.vecs[2].texVec.w = 1 / triProjected.vecs[2].w
-
-						triProjected.vecs[0] = triProjected.vecs[0].Div(triProjected.vecs[0].w)
-						triProjected.vecs[1] = triProjected.vecs[1].Div(triProjected.vecs[1].w)
-						triProjected.vecs[2] = triProjected.vecs[2].Div(triProjected.vecs[2].w)
-
-						// Offset into view
-						vOffsetView := Vector4{1, 1, 0, 0, -1, NewTexVector(0, 0, 0)}
-						triProjected.vecs[0] = triProjected.vecs[0].Add(vOffsetView)
-						triProjected.vecs[1] = triProjected.vecs[1].Add(vOffsetView)
-						triProjected.vecs[2] = triProjected.vecs[2].Add(vOffsetView)
-
-						triProjected.vecs[0].originalZ = triTransformed.vecs[0].originalZ
-						triProjected.vecs[1].originalZ = triTransformed.vecs[1].originalZ
-						triProjected.vecs[2].originalZ = triTransformed.vecs[2].originalZ
-
-						// Expand to screen size
-						triProjected.vecs[0].x *= RENDER_WIDTH_HALF
-						triProjected.vecs[0].y *= RENDER_HEIGHT_HALF
-						triProjected.vecs[1].x *= RENDER_WIDTH_HALF
-						triProjected.vecs[1].y *= RENDER_HEIGHT_HALF
-						triProjected.vecs[2].x *= RENDER_WIDTH_HALF
-						triProjected.vecs[2].y *= RENDER_HEIGHT_HALF
-
-						triangles = append(triangles, triProjected)
-					}
-				}
+		// Point clouds have no lines, so the button sets their point size instead
+		if pressed := btnVisualToolsLineWidth.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+			if modelMesh != nil && modelMesh.isPointCloud() {
+				pointSizeIdx = (pointSizeIdx + 1) % len(POINT_SIZES)
+			} else {
+				lineWidthIdx = (lineWidthIdx + 1) % len(LINE_WIDTHS)
 			}
+			updateLineWidthButton()
+		}
 
-			// Check for clipping and draw triangles to screen.
-			for _, triToRaster := range triangles {
-				clipped := []Triangle{}
-				listTriangles := []Triangle{}
-				listTriangles = append(listTriangles, triToRaster)
-				nNewTriangles := 1
-
-				for p := 0; p < 4; p++ {
-					nTrisToAdd := 0
-					for nNewTriangles > 0 {
-						curTri := listTriangles[0]
-						listTriangles = listTriangles[1:]
-						nNewTriangles--
-
-						// Clip against each plane (screen borders)
-						switch p {
-						case 0:
-							clipped = ClipAgainstPlane(Vector4{0, 0, 0, 1, -1, NewTexVector(0, 0, 0)}, Vector4{0, 1, 0, 1, -1, NewTexVector(0, 0, 0)}, curTri)
-						case 1:
-							clipped = ClipAgainstPlane(Vector4{0, RENDER_HEIGHT_FLOAT, 0, 1, -1, NewTexVector(0, 0, 0)}, Vector4{0, -1, 0, 1, -1, NewTexVector(0, 0, 0)}, curTri)
-						case 2:
-							clipped = ClipAgainstPlane(Vector4{0, 0, 0, 1, -1, NewTexVector(0, 0, 0)}, Vector4{1, 0, 0, 1, -1, NewTexVector(0, 0, 0)}, curTri)
-						case 3:
-							clipped = ClipAgainstPlane(Vector4{RENDER_WIDTH_FLOAT, 0, 0, 1, -1, NewTexVector(0, 0, 0)}, Vector4{-1, 0, 0, 1, -1, NewTexVector(0, 0, 0)}, curTri)
-						}
-
-						nTrisToAdd = len(clipped)
-						for w := 0; w < nTrisToAdd; w++ {
-							listTriangles = append(listTriangles, clipped[w])
-						}
-					}
-
-					nNewTriangles = len(listTriangles)
-				}
+		if pressed := btnVisualToolsLineColor.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+			lineColorIdx = (lineColorIdx + 1) % len(LINE_COLORS)
+			updateLineColorButton()
+		}
 
-				for _, tri := range listTriangles {
-					tri.Draw()
-				}
-			}
+		if pressed := btnVisualToolsFilter.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+			textureFilter = (textureFilter + 1) % TextureFilter(len(textureFilterNames))
+			lblVisualToolsFilter.SetText(textureFilter.String() + " filter")
 		}
 
-		if SCALE_FACTOR > 1 {
-			var wg sync.WaitGroup
+		if pressed := btnVisualToolsShadows.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+			showShadows = !showShadows
+			updateShadowsButton()
+		}
 
-			for y := 0; y < int(RENDER_HEIGHT); y++ {
-				wg.Add(1)
-				go func(y int) {
-					defer wg.Done()
-					for x := 0; x < RENDER_WIDTH; x++ {
+		if pressed := btnVisualToolsOcclusion.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+			showOcclusion = !showOcclusion
+			updateOcclusionButton()
+		}
 
-						screenIdx := y*int(SCREEN_WIDTH)*SCALE_FACTOR*4 + x*SCALE_FACTOR*4
-						renderIdx := (y*RENDER_WIDTH + x) * 4
+		if pressed := btnVisualToolsFlipNormals.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+			flipNormals = !flipNormals
+		}
 
-						for b := 0; b < 4; b++ {
-							for dy := 0; dy < SCALE_FACTOR; dy++ {
-								for dx := 0; dx < SCALE_FACTOR; dx++ {
-									screenBuffer[screenIdx+int(SCREEN_WIDTH)*4*dy+4*dx+b] = renderBuffer[renderIdx+b]
-								}
-							}
-						}
-					}
-				}(y)
+		if pressed := btnVisualToolsResetView.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+			ResetCameraView()
+		}
+
+		if pressed := btnVisualToolsCamera.UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+			toggleFlyMode()
+		}
+
+		if !resolutionHidden() {
+			for i := range btnResolution {
+				if pressed := btnResolution[i].UpdateAndGetStatus(curX, curY, MOUSE_CLICK); pressed {
+					setScale(RENDER_SCALES[i])
+				}
 			}
+		}
 
-			wg.Wait()
+		// Main 3D code
+		renderer.Clear()
+		if modelMesh != nil {
+			renderer.flipNormals = flipNormals
+			renderer.shading = shadingMode
+			renderer.mode = renderMode
+			renderer.filter = textureFilter
+			renderer.lineWidth = LINE_WIDTHS[lineWidthIdx]
+			renderer.pointSize = POINT_SIZES[pointSizeIdx]
+			renderer.lights = lights
+			renderer.ambient = NewColorVector(ambientLight, ambientLight, ambientLight)
+			renderer.shadows = showShadows
+			renderer.shadowBias = shadowBias
+			renderer.ssao = showOcclusion
+			renderer.ssaoRadius = occlusionRadius
+			renderer.ssaoSamples = occlusionSamples
+			renderer.ssaoBlur = occlusionBlur
+			renderer.lineColor = LINE_COLORS[lineColorIdx]
+			renderer.DrawMesh(modelMesh, camera.World())
 		}
 
+		presentRender()
+
 		// Draw UI elements
 		btnLoadMesh.Draw(surface)
 		lblLoadMesh.Draw(surface)
+		if modelMesh != nil {
+			btnSaveMesh.Draw(surface)
+			lblSaveMesh.Draw(surface)
+		}
+
+		if time.Since(loadErrorTime) < LOAD_ERROR_DURATION && !showLights {
+			cbLoadError.Draw(surface)
+			lblLoadError.Draw(surface)
+		}
 
 		if modelMesh != nil {
 			cbFileInfo.Draw(surface)
 			lblFileInfoName.Draw(surface)
+			lblFileInfoFaces.Draw(surface)
 			lblFileInfoTriangles.Draw(surface)
 			lblFileInfoVertices.Draw(surface)
-		} else {
+			btnFileInfoDetails.Draw(surface)
+			lblFileInfoDetails.Draw(surface)
+			if showFileInfoReport {
+				for i := range lblFileInfoReport {
+					lblFileInfoReport[i].Draw(surface)
+				}
+			}
+		} else if loading == nil {
 			lblNoMeshLoaded.Draw(surface)
 		}
 
+		if loading != nil {
+			cbLoading.Draw(surface)
+			lblLoading.Draw(surface)
+			cbLoadingBar.Draw(surface)
+			cbLoadingBarFill.Draw(surface)
+			btnLoadingCancel.Draw(surface)
+			lblLoadingCancel.Draw(surface)
+		}
+
 		cbFps.Draw(surface)
 		lblFps.Draw(surface)
 
 		cbVisualTools.Draw(surface)
 		lblVisualToolsTitle.Draw(surface)
+		btnVisualToolsShading.Draw(surface)
 		btnVisualToolsFlipNormals.Draw(surface)
 		btnVisualToolsResetView.Draw(surface)
+		lblVisualToolsShading.Draw(surface)
 		lblVisualToolsFlipNormals.Draw(surface)
 		lblVisualToolsResetView.Draw(surface)
+		btnVisualToolsCamera.Draw(surface)
+		lblVisualToolsCamera.Draw(surface)
+		for i := range btnVisualToolsModes {
+			btnVisualToolsModes[i].Draw(surface)
+			lblVisualToolsModes[i].Draw(surface)
+		}
+		btnVisualToolsLineWidth.Draw(surface)
+		lblVisualToolsLineWidth.Draw(surface)
+		btnVisualToolsLineColor.Draw(surface)
+		btnVisualToolsFilter.Draw(surface)
+		lblVisualToolsFilter.Draw(surface)
+		btnVisualToolsShadows.Draw(surface)
+		lblVisualToolsShadows.Draw(surface)
+		btnVisualToolsOcclusion.Draw(surface)
+		lblVisualToolsOcclusion.Draw(surface)
+
+		if !resolutionHidden() {
+			cbResolution.Draw(surface)
+			lblResolutionTitle.Draw(surface)
+			for i := range btnResolution {
+				btnResolution[i].Draw(surface)
+				lblResolution[i].Draw(surface)
+			}
+		}
 
-		cbResolution.Draw(surface)
-		lblResolutionTitle.Draw(surface)
-		btnResolution1.Draw(surface)
-		lblResolution1.Draw(surface)
-		btnResolution2.Draw(surface)
-		lblResolution2.Draw(surface)
-		btnResolution4.Draw(surface)
-		lblResolution4.Draw(surface)
-		btnResolution8.Draw(surface)
-		lblResolution8.Draw(surface)
-		btnResolution16.Draw(surface)
-		lblResolution16.Draw(surface)
-
-		// Update and clean screen buffers
-		window.UpdateSurface()
+		drawLightsPanel(surface)
 
-		for i := 0; i < len(renderBuffer); i += 4 {
-			renderBuffer[i] = byte((BG_COLOR >> 16) & 0xff)
-			renderBuffer[i+1] = byte((BG_COLOR >> 8) & 0xff)
-			renderBuffer[i+2] = byte(BG_COLOR & 0xff)
-			renderBuffer[i+3] = byte((BG_COLOR >> 24) & 0xff)
+		btnRecentFiles.Draw(surface)
+		lblRecentFiles.Draw(surface)
+		if showRecentFiles {
+			cbRecentFiles.Draw(surface)
+			for i := range recentFiles {
+				btnRecentFile[i].Draw(surface)
+				lblRecentFile[i].Draw(surface)
+			}
+			if len(recentFiles) == 0 {
+				lblNoRecentFiles.Draw(surface)
+			}
 		}
 
-		for i := 0; i < len(depthBuffer); i++ {
-			depthBuffer[i] = math.MaxFloat64
-		}
+		// Update screen. The render buffers are only cleared before the next frame, so the depth
+		// buffer can be used to pick the surface under the cursor.
+		window.UpdateSurface()
 	}
 
 }
 
+// createUI creates the UI elements. They are positioned by layoutUI, as it depends on the window size.
+func createUI() {
+	btnLoadMesh = ui.NewButton(0, 0, 110, 25, ui.NewMargin(10, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	lblLoadMesh = ui.NewLabel(0, 0, "Load file", ui.NewMargin(10, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+
+	btnSaveMesh = ui.NewButton(0, 0, 110, 25, ui.NewMargin(10, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	lblSaveMesh = ui.NewLabel(0, 0, "Save as", ui.NewMargin(10, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+
+	btnRecentFiles = ui.NewButton(0, 0, 110, 25, ui.NewMargin(10, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	lblRecentFiles = ui.NewLabel(0, 0, "Recent files", ui.NewMargin(10, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	cbRecentFiles = ui.NewContentBlock(0, 0, 300, 22, ui.NewMargin(10, 10), ui.NewPadding(10, 5), ui.TOP_LEFT, 0x001a1a1a)
+	for i := range btnRecentFile {
+		btnRecentFile[i] = ui.NewButton(0, 0, 300, 22, ui.NewMargin(20, 10), ui.TOP_LEFT, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+		lblRecentFile[i] = ui.NewLabel(0, 0, " ", ui.NewMargin(25, 10), ui.TOP_LEFT, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	}
+	lblNoRecentFiles = ui.NewLabel(0, 0, "No recent files", ui.NewMargin(25, 10), ui.TOP_LEFT, sdl.Color{R: 127, G: 127, B: 127, A: 255}, fontSmall)
+
+	createLightsPanel()
+
+	cbLoading = ui.NewContentBlock(0, 0, 380, 40, ui.NewMargin(0, 10), ui.NewPadding(10, 8), ui.TOP_CENTER, 0x001a1a1a)
+	lblLoading = ui.NewLabel(0, 0, " ", ui.NewMargin(0, 0), ui.TOP_LEFT, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
+	cbLoadingBar = ui.NewContentBlock(0, 0, LOADING_BAR_WIDTH, 10, ui.NewMargin(0, 0), ui.NewPadding(0, 0), ui.TOP_LEFT, 0x00444444)
+	cbLoadingBarFill = ui.NewContentBlock(0, 0, 0, 10, ui.NewMargin(0, 0), ui.NewPadding(0, 0), ui.TOP_LEFT, 0x00dddddd)
+	btnLoadingCancel = ui.NewButton(0, 0, 80, 25, ui.NewMargin(0, 0), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	lblLoadingCancel = ui.NewLabel(0, 0, "Cancel", ui.NewMargin(0, 0), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+
+	cbLoadError = ui.NewContentBlock(0, 0, 0, 20, ui.NewMargin(10, 10), ui.NewPadding(10, 5), ui.TOP_LEFT, 0x00602020)
+	lblLoadError = ui.NewLabel(0, 0, " ", ui.NewMargin(20, 10), ui.TOP_LEFT, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
+
+	cbFileInfo = ui.NewContentBlock(0, 0, FILE_INFO_WIDTH, 100, ui.NewMargin(10, 10), ui.NewPadding(10, 13), ui.TOP_RIGHT, 0x001a1a1a)
+	lblFileInfoName = ui.NewLabel(0, 0, " ", ui.NewMargin(20, 10), ui.TOP_RIGHT, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontRegular)
+	lblFileInfoFaces = ui.NewLabel(0, 0, " ", ui.NewMargin(20, 10), ui.TOP_RIGHT, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
+	lblFileInfoTriangles = ui.NewLabel(0, 0, " ", ui.NewMargin(20, 10), ui.TOP_RIGHT, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
+	lblFileInfoVertices = ui.NewLabel(0, 0, " ", ui.NewMargin(20, 10), ui.TOP_RIGHT, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
+	btnFileInfoDetails = ui.NewButton(0, 0, FILE_INFO_WIDTH, 22, ui.NewMargin(20, 10), ui.TOP_RIGHT, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	lblFileInfoDetails = ui.NewLabel(0, 0, " ", ui.NewMargin(0, 0), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	for i := range lblFileInfoReport {
+		lblFileInfoReport[i] = ui.NewLabel(0, 0, " ", ui.NewMargin(20, 10), ui.TOP_RIGHT, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
+	}
+
+	cbFps = ui.NewContentBlock(0, 0, 85, 20, ui.NewMargin(0, 10), ui.NewPadding(0, 0), ui.TOP_CENTER, 0x00000000)
+	lblFps = ui.NewLabel(0, 0, " ", ui.NewMargin(0, 10), ui.TOP_CENTER, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
+
+	cbVisualTools = ui.NewContentBlock(0, 0, 110, 290, ui.NewMargin(10, 10), ui.NewPadding(10, 10), ui.BOTTOM_RIGHT, 0x001a1a1a)
+	lblVisualToolsTitle = ui.NewLabel(0, 0, "Visual tools", ui.NewMargin(20, 10), ui.BOTTOM_CENTER, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
+	btnVisualToolsShading = ui.NewButton(0, 0, 110, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	btnVisualToolsFlipNormals = ui.NewButton(0, 0, 110, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	btnVisualToolsResetView = ui.NewButton(0, 0, 110, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	lblVisualToolsShading = ui.NewLabel(0, 0, shadingMode.String()+" shading", ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	lblVisualToolsFlipNormals = ui.NewLabel(0, 0, "Flip normals", ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	lblVisualToolsResetView = ui.NewLabel(0, 0, "Reset view", ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	btnVisualToolsCamera = ui.NewButton(0, 0, 110, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	lblVisualToolsCamera = ui.NewLabel(0, 0, " ", ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	updateCameraLabel()
+	for i := range btnVisualToolsModes {
+		btnVisualToolsModes[i] = ui.NewButton(0, 0, 25, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+		lblVisualToolsModes[i] = ui.NewLabel(0, 0, renderModeButtonNames[i], ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	}
+	updateRenderModeButtons()
+	btnVisualToolsLineWidth = ui.NewButton(0, 0, 80, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	lblVisualToolsLineWidth = ui.NewLabel(0, 0, " ", ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	btnVisualToolsLineColor = ui.NewButton(0, 0, 25, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	btnVisualToolsFilter = ui.NewButton(0, 0, 110, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	lblVisualToolsFilter = ui.NewLabel(0, 0, textureFilter.String()+" filter", ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	btnVisualToolsShadows = ui.NewButton(0, 0, 110, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	lblVisualToolsShadows = ui.NewLabel(0, 0, " ", ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	btnVisualToolsOcclusion = ui.NewButton(0, 0, 110, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+	lblVisualToolsOcclusion = ui.NewLabel(0, 0, " ", ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	updateLineColorButton()
+	updateLineWidthButton()
+	updateShadowsButton()
+	updateOcclusionButton()
+
+	lblNoMeshLoaded = ui.NewLabel(0, 0, fmt.Sprintf("Load a 3D file to preview it (%s supported)", supportedExtensionsText()), ui.NewMargin(0, 0), ui.CENTER_CENTER, sdl.Color{R: 127, G: 127, B: 127, A: 255}, fontBig)
+
+	cbResolution = ui.NewContentBlock(0, 0, 170, 50, ui.NewMargin(10, 10), ui.NewPadding(10, 10), ui.BOTTOM_LEFT, 0x001a1a1a)
+	lblResolutionTitle = ui.NewLabel(0, 0, "Resolution", ui.NewMargin(20, 10), ui.BOTTOM_CENTER, sdl.Color{R: 255, G: 255, B: 255, A: 255}, fontSmall)
+	for i := range btnResolution {
+		btnResolution[i] = ui.NewButton(0, 0, 25, 25, ui.NewMargin(20, 10), ui.CENTER_CENTER, 0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+		lblResolution[i] = ui.NewLabel(0, 0, renderScaleButtonNames[i], ui.NewMargin(20, 10), ui.CENTER_CENTER, sdl.Color{R: 0, G: 0, B: 0, A: 255}, fontSmall)
+	}
+	updateRenderScaleButtons()
+}
+
+// layoutUI positions the UI elements for the current window size, anchoring them to its corners.
+func layoutUI() {
+	W, H := int32(SCREEN_WIDTH), int32(SCREEN_HEIGHT)
+
+	btnLoadMesh.SetPosition(110/2+20, 25/2+10)
+	lblLoadMesh.SetPosition(110/2+20, 25/2+10+3)
+
+	btnRecentFiles.SetPosition(110/2+20+120, 25/2+10)
+	lblRecentFiles.SetPosition(110/2+20+120, 25/2+10+3)
+	btnSaveMesh.SetPosition(110/2+20+360, 25/2+10)
+	lblSaveMesh.SetPosition(110/2+20+360, 25/2+10+3)
+	layoutLightsPanel()
+	cbRecentFiles.SetPosition(0, 40)
+	for i := range btnRecentFile {
+		btnRecentFile[i].SetPosition(0, 45+26*int32(i))
+		lblRecentFile[i].SetPosition(0, 48+26*int32(i))
+	}
+	lblNoRecentFiles.SetPosition(0, 48)
+
+	cbLoading.SetPosition(W/2, 30)
+	lblLoading.SetPosition(W/2-190, 46)
+	cbLoadingBar.SetPosition(W/2-190, 72)
+	cbLoadingBarFill.SetPosition(W/2-190, 72)
+	btnLoadingCancel.SetPosition(W/2+150, 55)
+	lblLoadingCancel.SetPosition(W/2+150, 58)
+
+	cbLoadError.SetPosition(0, 40)
+	lblLoadError.SetPosition(0, 45)
+
+	cbFileInfo.SetPosition(W, 0)
+	lblFileInfoName.SetPosition(W, 5)
+	lblFileInfoFaces.SetPosition(W, 30)
+	lblFileInfoTriangles.SetPosition(W, 50)
+	lblFileInfoVertices.SetPosition(W, 70)
+	btnFileInfoDetails.SetPosition(W, 95)
+	lblFileInfoDetails.SetPosition(W-20-FILE_INFO_WIDTH/2, 109)
+	for i := range lblFileInfoReport {
+		lblFileInfoReport[i].SetPosition(W, 130+20*int32(i))
+	}
+
+	cbFps.SetPosition(W/2, 0)
+	lblFps.SetPosition(W/2, 0)
+
+	cbVisualTools.SetPosition(W, H)
+	lblVisualToolsTitle.SetPosition(W-65, H-285)
+	for i := range btnVisualToolsModes {
+		x := W - 98 + 28*int32(i)
+		btnVisualToolsModes[i].SetPosition(x, H-275)
+		lblVisualToolsModes[i].SetPosition(x, H-270-2)
+	}
+	btnVisualToolsLineWidth.SetPosition(W-70, H-245)
+	lblVisualToolsLineWidth.SetPosition(W-70, H-240-2)
+	btnVisualToolsLineColor.SetPosition(W-14, H-245)
+	btnVisualToolsFilter.SetPosition(W-110/2, H-215)
+	lblVisualToolsFilter.SetPosition(W-110/2, H-210-2)
+	btnVisualToolsShadows.SetPosition(W-110/2, H-185)
+	lblVisualToolsShadows.SetPosition(W-110/2, H-180-2)
+	btnVisualToolsOcclusion.SetPosition(W-110/2, H-155)
+	lblVisualToolsOcclusion.SetPosition(W-110/2, H-150-2)
+	btnVisualToolsShading.SetPosition(W-110/2, H-125)
+	lblVisualToolsShading.SetPosition(W-110/2, H-120-2)
+	btnVisualToolsFlipNormals.SetPosition(W-110/2, H-95)
+	lblVisualToolsFlipNormals.SetPosition(W-110/2, H-90-2)
+	btnVisualToolsCamera.SetPosition(W-110/2, H-65)
+	lblVisualToolsCamera.SetPosition(W-110/2, H-60-2)
+	btnVisualToolsResetView.SetPosition(W-110/2, H-35)
+	lblVisualToolsResetView.SetPosition(W-110/2, H-30-2)
+
+	lblNoMeshLoaded.SetPosition(W/2, H/2)
+
+	cbResolution.SetPosition(0, H)
+	lblResolutionTitle.SetPosition(115, H-45)
+	for i := range btnResolution {
+		x := 50 + 30*int32(i)
+		btnResolution[i].SetPosition(x, H-35)
+		lblResolution[i].SetPosition(x, H-32)
+	}
+}
+
+// A model being loaded in the background. The current mesh stays visible until it is done.
+type loadJob struct {
+	filename string
+	progress *LoadProgress
+	cancel   context.CancelFunc
+	done     chan loadResult
+	text     string // Progress text shown, only rendered again when it changes
+}
+
+type loadResult struct {
+	mesh   *Mesh
+	report MeshReport
+	err    error
+}
+
+// LoadFile starts loading the model in the background, cancelling the previous load if any.
 func LoadFile(modelFilePath string) {
-	modelMesh = ParseObj(modelFilePath)
+	CancelLoad()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &loadJob{filename: modelFilePath, progress: &LoadProgress{}, cancel: cancel, done: make(chan loadResult, 1)}
+	loading = job
+	updateLoadingUI()
+
+	go func() {
+		ctx := WithLoadProgress(ctx, job.progress)
+
+		mesh, err := LoadMesh(ctx, modelFilePath)
+		var report MeshReport
+		if err == nil {
+			report, err = AnalyzeMesh(ctx, mesh)
+		}
+		job.done <- loadResult{mesh, report, err}
+	}()
+}
+
+func CancelLoad() {
+	if loading != nil {
+		loading.cancel()
+		loading = nil
+	}
+}
+
+// updateLoading swaps in the loaded mesh once it is ready, between frames, or shows the progress
+// of the load meanwhile.
+func updateLoading() {
+	if loading == nil {
+		return
+	}
+
+	select {
+	case result := <-loading.done:
+		job := loading
+		loading = nil
+		job.cancel()
+		finishLoad(job.filename, result)
+	default:
+		updateLoadingUI()
+	}
+}
+
+func updateLoadingUI() {
+	text := loading.progress.Phase().String() + "..."
+	fraction := loading.progress.Fraction()
+	if fraction >= 0 {
+		text = fmt.Sprintf("%s... %d%%", loading.progress.Phase(), int(fraction*100))
+	}
+
+	if text != loading.text {
+		loading.text = text
+		lblLoading.SetText(text)
+	}
+	cbLoadingBarFill.UpdateRectToWidth(int32(math.Max(fraction, 0) * float64(LOADING_BAR_WIDTH)))
+}
+
+func finishLoad(modelFilePath string, result loadResult) {
+	if err := result.err; err != nil {
+		if _, statErr := os.Stat(modelFilePath); errors.Is(statErr, os.ErrNotExist) {
+			updateRecentFiles(removeRecentFile(recentFiles, modelFilePath))
+		}
+
+		ShowLoadError(err)
+		return
+	}
+
+	updateRecentFiles(addRecentFile(recentFiles, modelFilePath))
+
+	modelMesh = result.mesh
+	modelFilename = modelFilePath
+	meshReport = result.report
+	loadErrorTime = time.Time{}
 
 	ResetCameraView()
 
@@ -521,43 +861,440 @@ func LoadFile(modelFilePath string) {
 
 	// This may not work properly (strange bug happening when rendering text after loading a 3D mesh...)
 	lblFileInfoName.SetText(filename)
+	lblFileInfoFaces.SetText(fmt.Sprintf("Faces: %d", modelMesh.faceAmount))
 	lblFileInfoTriangles.SetText(fmt.Sprintf("Triangles: %d", modelMesh.triangleAmount))
 	lblFileInfoVertices.SetText(fmt.Sprintf("Vertices: %d", modelMesh.vertexAmount))
+	for i, line := range meshReport.Lines() {
+		lblFileInfoReport[i].SetText(line)
+	}
+	updateFileInfoPanel()
+	updateLineWidthButton()
+}
+
+// updateFileInfoPanel expands the file info panel to show the mesh report, or collapses it.
+func updateFileInfoPanel() {
+	text := "Show details"
+	if showFileInfoReport {
+		text = "Hide details"
+	}
+	if meshReport.Issues() {
+		text += " (issues)"
+	}
+	lblFileInfoDetails.SetText(text)
+
+	if showFileInfoReport {
+		cbFileInfo.UpdateRectToWidth(FILE_INFO_REPORT_WIDTH)
+		cbFileInfo.UpdateRectToHeight(105 + 20*int32(MESH_REPORT_LINES))
+	} else {
+		cbFileInfo.UpdateRectToWidth(FILE_INFO_WIDTH)
+		cbFileInfo.UpdateRectToHeight(100)
+	}
+}
+
+// updateRecentFiles saves the new recent files list and shows it in the UI.
+func updateRecentFiles(files []string) {
+	recentFiles = files
+	if err := SaveRecentFiles(recentFiles); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving the recent files: %s\n", err)
+	}
+
+	updateRecentFilesList()
+}
+
+func updateRecentFilesList() {
+	for i, filename := range recentFiles {
+		text := fmt.Sprintf("%s  (%s)", filepath.Base(filename), filepath.Dir(filename))
+		if runes := []rune(text); len(runes) > RECENT_FILE_TEXT_LENGTH {
+			text = string(runes[:RECENT_FILE_TEXT_LENGTH-3]) + "..."
+		}
+		lblRecentFile[i].SetText(text)
+	}
+
+	cbRecentFiles.UpdateRectToHeight(int32(max(len(recentFiles), 1))*26 - 4)
+}
+
+// fileDialogStart returns the folder the file dialog opens in: the one of the last opened model,
+// or the home folder.
+func fileDialogStart() string {
+	if len(recentFiles) > 0 {
+		return filepath.Dir(recentFiles[0]) + string(filepath.Separator)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return home + string(filepath.Separator)
+	}
+
+	return "/"
+}
+
+// SaveFile writes the current model to the given path, in the format of its extension (.obj if
+// it has none). Errors are shown on screen.
+func SaveFile(filename string) {
+	if filepath.Ext(filename) == "" {
+		filename += ".obj"
+	}
+
+	if err := SaveMesh(filename, modelMesh, EncodeOptions{}); err != nil {
+		ShowSaveError(err)
+	}
+}
+
+func ShowLoadError(err error) {
+	showError(fmt.Sprintf("Error loading file: %s", err))
 }
 
+func ShowSaveError(err error) {
+	showError(fmt.Sprintf("Error saving file: %s", err))
+}
+
+// showError shows the message for a while, closing the lights panel that would cover it.
+func showError(message string) {
+	lblLoadError.SetText(message)
+	cbLoadError.UpdateRectToWidth(lblLoadError.GetRectWidth())
+	loadErrorTime = time.Now()
+	showLights = false
+}
+
+// resolutionHidden returns whether the lights panel covers the resolution block, on short windows,
+// which is then hidden so only the panel gets the clicks.
+func resolutionHidden() bool {
+	return showLights && cbLights.Overlaps(cbResolution)
+}
+
+// ResetCameraView frames the model again, keeping the camera mode.
 func ResetCameraView() {
-	positionOffset = Vector4{0, DEFAULT_Y_OFFSET, DEFAULT_Z_OFFSET, 0, -1, NewTexVector(0, 0, 0)}
-	rotationTheta = Vector4{0, DEFAULT_Y_ROTATION, 0, 0, -1, NewTexVector(0, 0, 0)}
+	fly := camera.fly
+	camera = DefaultCamera(modelMesh)
+	camera.SetFly(fly)
+}
+
+// uiContains returns whether the point is over a visible button or panel.
+func uiContains(x, y int32) bool {
+	if btnLoadMesh.Contains(x, y) || btnRecentFiles.Contains(x, y) || btnLights.Contains(x, y) ||
+		cbVisualTools.Contains(x, y) || (!resolutionHidden() && cbResolution.Contains(x, y)) {
+		return true
+	}
+
+	return (modelMesh != nil && (btnSaveMesh.Contains(x, y) || cbFileInfo.Contains(x, y))) ||
+		(loading != nil && cbLoading.Contains(x, y)) ||
+		(time.Since(loadErrorTime) < LOAD_ERROR_DURATION && !showLights && cbLoadError.Contains(x, y)) ||
+		(showRecentFiles && cbRecentFiles.Contains(x, y)) ||
+		(showLights && cbLights.Contains(x, y))
+}
+
+// updateOrbitCamera handles the orbit camera input. Left drag orbits, middle (or shift + left) drag
+// pans, the wheel zooms towards the cursor and double clicking sets the orbit pivot. Left presses
+// that began over the UI are left to it.
+func updateOrbitCamera(prevX, prevY, curX, curY int32) {
+	width, height := float64(SCREEN_WIDTH), float64(SCREEN_HEIGHT)
+	moved := curX != prevX || curY != prevY
+	dragging := MOUSE_CLICK && !MOUSE_ON_UI
+
+	if MOUSE_MIDDLE || (dragging && sdl.GetModState()&sdl.KMOD_SHIFT != 0) {
+		if moved {
+			camera.Pan(float64(curX-prevX), float64(curY-prevY), height)
+		}
+	} else if dragging && moved {
+		camera.Arcball(float64(prevX), float64(prevY), float64(curX), float64(curY), width, height)
+	}
+
+	if MOUSE_WHEEL != 0 {
+		camera.Zoom(math.Pow(CAMERA_ZOOM_STEP, float64(MOUSE_WHEEL)), float64(curX), float64(curY), width, height)
+	}
+
+	if MOUSE_DOUBLE_CLICK && !MOUSE_ON_UI {
+		// The depth buffer still holds the last frame
+		scale := float64(renderer.width) / width
+		if point, ok := renderer.Unproject(int(float64(curX)*scale), int(float64(curY)*scale)); ok {
+			camera.SetPivot(point)
+		}
+	}
+
+	keyPan := KEY_PAN_SPEED * tDelta
+	if UP_PRESSED {
+		camera.Pan(0, keyPan, height)
+	}
+	if DOWN_PRESSED {
+		camera.Pan(0, -keyPan, height)
+	}
+	if LEFT_PRESSED {
+		camera.Pan(keyPan, 0, height)
+	}
+	if RIGHT_PRESSED {
+		camera.Pan(-keyPan, 0, height)
+	}
+}
+
+// updateFlyCamera handles the fly camera input. WASD moves, Q and E go down and up, and holding the
+// right button captures the mouse to look around. Shift moves faster, Ctrl slower, and the wheel
+// changes the speed.
+func updateFlyCamera() {
+	if MOUSE_RIGHT != sdl.GetRelativeMouseMode() {
+		sdl.SetRelativeMouseMode(MOUSE_RIGHT)
+		sdl.GetRelativeMouseState() // Discard the motion from before the capture
+	}
+	if MOUSE_RIGHT {
+		dx, dy, _ := sdl.GetRelativeMouseState()
+		camera.Look(float64(dx), float64(dy))
+	}
+
+	if MOUSE_WHEEL != 0 {
+		camera.flySpeed *= math.Pow(FLY_SPEED_STEP, float64(MOUSE_WHEEL))
+	}
+
+	speed := camera.flySpeed * tDelta
+	if mod := sdl.GetModState(); mod&sdl.KMOD_SHIFT != 0 {
+		speed *= FLY_FAST_FACTOR
+	} else if mod&sdl.KMOD_CTRL != 0 {
+		speed *= FLY_SLOW_FACTOR
+	}
+
+	var forward, right, up float64
+	if FLY_KEYS_PRESSED[sdl.K_w] {
+		forward += speed
+	}
+	if FLY_KEYS_PRESSED[sdl.K_s] {
+		forward -= speed
+	}
+	if FLY_KEYS_PRESSED[sdl.K_d] {
+		right += speed
+	}
+	if FLY_KEYS_PRESSED[sdl.K_a] {
+		right -= speed
+	}
+	if FLY_KEYS_PRESSED[sdl.K_e] {
+		up += speed
+	}
+	if FLY_KEYS_PRESSED[sdl.K_q] {
+		up -= speed
+	}
+
+	camera.Fly(forward, right, up)
+}
 
-	if modelMesh != nil {
-		positionOffset.z = -modelMesh.lowestZ * 3
-		positionOffset.y = -(modelMesh.lowestY + modelMesh.highestY) / 2
+// toggleFlyMode switches the camera between orbiting the model and flying through it.
+func toggleFlyMode() {
+	camera.SetFly(!camera.fly)
+	if !camera.fly {
+		sdl.SetRelativeMouseMode(false)
 	}
+
+	updateCameraLabel()
 }
 
-func setScale(scale int) {
-	if scale != 1 && scale != 2 && scale != 4 && scale != 8 && scale != 16 {
-		log.Fatalf("Unexpected resolution scale '%d'", scale)
+func updateCameraLabel() {
+	if camera.fly {
+		lblVisualToolsCamera.SetText("Fly camera")
+	} else {
+		lblVisualToolsCamera.SetText("Orbit camera")
 	}
+}
 
-	SCALE_FACTOR = scale
+// updateRenderModeButtons shows the button of the current render mode as pressed.
+func updateRenderModeButtons() {
+	for i := range btnVisualToolsModes {
+		if RenderMode(i) == renderMode {
+			btnVisualToolsModes[i].SetColors(0xbbbbbbbb, 0xbbbbbbbb, 0xbbbbbbbb)
+		} else {
+			btnVisualToolsModes[i].SetColors(0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+		}
+	}
+}
 
-	RENDER_WIDTH = SCREEN_WIDTH / SCALE_FACTOR
-	RENDER_HEIGHT = SCREEN_HEIGHT / SCALE_FACTOR
+// updateLineColorButton paints the line color button with the current line color.
+func updateLineColorButton() {
+	c := LINE_COLORS[lineColorIdx]
+	surfaceColor := 0xff000000 | uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B) // Surface is BGRA in memory
+	btnVisualToolsLineColor.SetColors(surfaceColor, surfaceColor, surfaceColor)
+}
 
-	RENDER_WIDTH_FLOAT = float64(RENDER_WIDTH)
-	RENDER_HEIGHT_FLOAT = float64(RENDER_HEIGHT)
+// updateLineWidthButton shows the line width, or the point size when the mesh is a point cloud.
+func updateLineWidthButton() {
+	if modelMesh != nil && modelMesh.isPointCloud() {
+		lblVisualToolsLineWidth.SetText(fmt.Sprintf("Point %gpx", POINT_SIZES[pointSizeIdx]))
+		return
+	}
 
-	RENDER_WIDTH_HALF = RENDER_WIDTH_FLOAT * 0.5
-	RENDER_HEIGHT_HALF = RENDER_HEIGHT_FLOAT * 0.5
+	lblVisualToolsLineWidth.SetText(fmt.Sprintf("Line %gpx", LINE_WIDTHS[lineWidthIdx]))
+}
+
+func updateShadowsButton() {
+	if showShadows {
+		lblVisualToolsShadows.SetText("Shadows on")
+	} else {
+		lblVisualToolsShadows.SetText("Shadows off")
+	}
+}
 
-	if SCALE_FACTOR > 1 {
-		renderBuffer = make([]byte, RENDER_WIDTH*RENDER_HEIGHT*4)
-		screenBuffer = surface.Pixels()
+func updateOcclusionButton() {
+	if showOcclusion {
+		lblVisualToolsOcclusion.SetText("Occlusion on")
 	} else {
-		renderBuffer = surface.Pixels()
+		lblVisualToolsOcclusion.SetText("Occlusion off")
+	}
+}
+
+// meshFileFilters returns the file dialog filters of the registered model formats, preceded by
+// one matching all of them.
+func meshFileFilters() zenity.FileFilters {
+	all := zenity.FileFilter{Name: "3D models", CaseFold: true}
+	filters := zenity.FileFilters{}
+
+	for _, format := range meshFormats {
+		patterns := []string{}
+		for _, extension := range format.extensions {
+			patterns = append(patterns, "*"+extension)
+		}
+
+		all.Patterns = append(all.Patterns, patterns...)
+		filters = append(filters, zenity.FileFilter{Name: format.name + " files", Patterns: patterns, CaseFold: true})
+	}
+
+	return append(zenity.FileFilters{all}, filters...)
+}
+
+// meshSaveFilters returns the file dialog filters of the formats models can be saved as.
+func meshSaveFilters() zenity.FileFilters {
+	filters := zenity.FileFilters{}
+	for _, encoder := range meshEncoders {
+		patterns := []string{}
+		for _, extension := range encoder.extensions {
+			patterns = append(patterns, "*"+extension)
+		}
+
+		filters = append(filters, zenity.FileFilter{Name: encoder.name + " files", Patterns: patterns, CaseFold: true})
+	}
+
+	return filters
+}
+
+// supportedExtensionsText lists the registered model extensions, as in ".obj, .stl and .glb".
+func supportedExtensionsText() string {
+	extensions := MeshExtensions()
+	if len(extensions) < 2 {
+		return strings.Join(extensions, "")
+	}
+
+	return strings.Join(extensions[:len(extensions)-1], ", ") + " and " + extensions[len(extensions)-1]
+}
+
+// setScale changes the render resolution to the given fraction of the window size.
+func setScale(scale float64) {
+	RENDER_SCALE = math.Max(MIN_RENDER_SCALE, math.Min(scale, 1))
+
+	width := max(1, int(math.Round(float64(SCREEN_WIDTH)*RENDER_SCALE)))
+	height := max(1, int(math.Round(float64(SCREEN_HEIGHT)*RENDER_SCALE)))
+
+	if renderer == nil {
+		renderer = NewRenderer(width, height)
+	} else if width != renderer.width || height != renderer.height {
+		renderer.Resize(width, height)
+	}
+
+	updateRenderScaleButtons()
+}
+
+// updateRenderScaleButtons shows the button of the current render scale as pressed.
+func updateRenderScaleButtons() {
+	for i := range btnResolution {
+		if RENDER_SCALES[i] == RENDER_SCALE {
+			btnResolution[i].SetColors(0xbbbbbbbb, 0xbbbbbbbb, 0xbbbbbbbb)
+		} else {
+			btnResolution[i].SetColors(0xffffffff, 0xdddddddd, 0xbbbbbbbb)
+		}
+	}
+}
+
+// resizeWindow adapts the render resolution and the UI to the new window size.
+func resizeWindow(window *sdl.Window) {
+	w, h := window.GetSize()
+	SCREEN_WIDTH, SCREEN_HEIGHT = int(w), int(h)
+
+	// The previous window surface is freed when the window is resized
+	var err error
+	surface, err = window.GetSurface()
+	if err != nil {
+		zenity.Error(fmt.Sprintf("Error getting the window surface.\n%s", err), zenity.Title("SDL2 error"), zenity.ErrorIcon)
+		panic(err)
+	}
+
+	setScale(RENDER_SCALE)
+	layoutUI()
+}
+
+func toggleFullscreen(window *sdl.Window) {
+	if window.GetFlags()&sdl.WINDOW_FULLSCREEN_DESKTOP == sdl.WINDOW_FULLSCREEN_DESKTOP {
+		window.SetFullscreen(0)
+	} else {
+		window.SetFullscreen(sdl.WINDOW_FULLSCREEN_DESKTOP)
+	}
+}
+
+// presentRender copies the render image into the window surface, upscaling it with bilinear
+// filtering when the render resolution is lower than the window one.
+func presentRender() {
+	screenBuffer := surface.Pixels()
+	pitch := int(surface.Pitch)
+	renderBuffer := renderer.image.Pix
+	renderWidth := renderer.width
+
+	if renderer.width == SCREEN_WIDTH && renderer.height == SCREEN_HEIGHT {
+		renderer.parallel(renderer.height, func(y int) {
+			for x := 0; x < renderWidth; x++ {
+				idx := y*pitch + x*4
+				renderIdx := (y*renderWidth + x) * 4
+
+				// The window surface is BGRA, the render image is RGBA
+				screenBuffer[idx+0] = renderBuffer[renderIdx+2]
+				screenBuffer[idx+1] = renderBuffer[renderIdx+1]
+				screenBuffer[idx+2] = renderBuffer[renderIdx+0]
+				screenBuffer[idx+3] = renderBuffer[renderIdx+3]
+			}
+		})
+		return
+	}
+
+	col0, col1, colWeight := upscaleSamples(SCREEN_WIDTH, renderer.width)
+	row0, row1, rowWeight := upscaleSamples(SCREEN_HEIGHT, renderer.height)
+
+	renderer.parallel(SCREEN_HEIGHT, func(y int) {
+		top, bottom := row0[y]*renderWidth*4, row1[y]*renderWidth*4
+		fy := rowWeight[y]
+
+		for x := 0; x < SCREEN_WIDTH; x++ {
+			left, right := col0[x]*4, col1[x]*4
+			fx := colWeight[x]
+			idx := y*pitch + x*4
+
+			// Fixed point blend, the weights being out of 256
+			for c := 0; c < 4; c++ {
+				t := int(renderBuffer[top+left+c])*(256-fx) + int(renderBuffer[top+right+c])*fx
+				b := int(renderBuffer[bottom+left+c])*(256-fx) + int(renderBuffer[bottom+right+c])*fx
+				value := uint8((t*(256-fy) + b*fy + 32768) >> 16)
+
+				// The window surface is BGRA, the render image is RGBA
+				if c < 3 {
+					screenBuffer[idx+2-c] = value
+				} else {
+					screenBuffer[idx+3] = value
+				}
+			}
+		}
+	})
+}
+
+// upscaleSamples returns, for each of the dst pixels, the two closest src pixels to its center
+// and the weight of the second one, out of 256.
+func upscaleSamples(dst, src int) ([]int, []int, []int) {
+	first, second, weight := make([]int, dst), make([]int, dst), make([]int, dst)
+
+	for i := 0; i < dst; i++ {
+		pos := (float64(i)+0.5)*float64(src)/float64(dst) - 0.5
+		floor := math.Floor(pos)
+
+		first[i] = min(max(int(floor), 0), src-1)
+		second[i] = min(max(int(floor)+1, 0), src-1)
+		weight[i] = int((pos - floor) * 256)
 	}
 
-	depthBuffer = make([]float64, RENDER_WIDTH*RENDER_HEIGHT)
-	depthBufferLength = len(depthBuffer)
+	return first, second, weight
 }
@@ -18,17 +18,31 @@ func (m mat44) multiplyVector(i Vector4) Vector4 {
 			i.texVec.u, i.texVec.v,
 			i.texVec.w,
 		),
+		i.normVec,
+		i.ilum,
+		i.spec,
+		i.color,
 	}
 }
 
+// multiplyNormal rotates the normal by the matrix, ignoring any translation.
+func (m mat44) multiplyNormal(n NormalVector) NormalVector {
+	return NormalVector{
+		n.x*m.m[0][0] + n.y*m.m[1][0] + n.z*m.m[2][0],
+		n.x*m.m[0][1] + n.y*m.m[1][1] + n.z*m.m[2][1],
+		n.x*m.m[0][2] + n.y*m.m[1][2] + n.z*m.m[2][2],
+	}
+}
+
+// multiplyTriangle transforms the vertices of the triangle, keeping the rest of its properties.
 func (m mat44) multiplyTriangle(t Triangle) Triangle {
-	return Triangle{
-		vecs: [3]Vector4{
-			m.multiplyVector(t.vecs[0]),
-			m.multiplyVector(t.vecs[1]),
-			m.multiplyVector(t.vecs[2]),
-		},
+	t.vecs = [3]Vector4{
+		m.multiplyVector(t.vecs[0]),
+		m.multiplyVector(t.vecs[1]),
+		m.multiplyVector(t.vecs[2]),
 	}
+
+	return t
 }
 
 func identityMatrix() mat44 {
@@ -42,23 +56,6 @@ func identityMatrix() mat44 {
 	}
 }
 
-func rotationMatrix(rotationRads Vector4) mat44 {
-	gamma := rotationRads.x
-	beta := rotationRads.y
-	alpha := rotationRads.z
-
-	mat := mat44{
-		m: [4][4]float64{
-			{math.Cos(alpha) * math.Cos(beta), math.Cos(alpha)*math.Sin(beta)*math.Sin(gamma) - math.Sin(alpha)*math.Cos(gamma), math.Cos(alpha)*math.Sin(beta)*math.Cos(gamma) + math.Sin(alpha)*math.Sin(gamma), 0},
-			{math.Sin(alpha) * math.Cos(beta), math.Sin(alpha)*math.Sin(beta)*math.Sin(gamma) + math.Cos(alpha)*math.Cos(gamma), math.Sin(alpha)*math.Sin(beta)*math.Cos(gamma) - math.Cos(alpha)*math.Sin(gamma), 0},
-			{-math.Sin(beta), math.Cos(beta) * math.Sin(gamma), math.Cos(beta) * math.Cos(gamma), 0},
-			{0, 0, 0, 1},
-		},
-	}
-
-	return mat
-}
-
 func MakeTranslation(x, y, z float64) mat44 {
 	return mat44{
 		m: [4][4]float64{
@@ -82,6 +79,23 @@ func projectionMatrix(aspectRatio, fovDeg, nearDist, farDist float64) mat44 {
 	}
 }
 
+// lookAtMatrix returns the view matrix of a camera at eye looking towards target, with the view
+// space of the renderer: looking towards +z, with +x to the left and +y up.
+func lookAtMatrix(eye, target, up Vector4) mat44 {
+	forward := target.Sub(eye).Normalise()
+	left := up.CrossProduct(forward).Normalise()
+	viewUp := forward.CrossProduct(left)
+
+	return mat44{
+		m: [4][4]float64{
+			{left.x, viewUp.x, forward.x, 0},
+			{left.y, viewUp.y, forward.y, 0},
+			{left.z, viewUp.z, forward.z, 0},
+			{-left.Dot(eye), -viewUp.Dot(eye), -forward.Dot(eye), 1},
+		},
+	}
+}
+
 func (m1 mat44) multiplyMatrix(m2 mat44) mat44 {
 	mat := mat44{}
 
@@ -93,3 +107,31 @@ func (m1 mat44) multiplyMatrix(m2 mat44) mat44 {
 
 	return mat
 }
+
+func (m mat44) determinant3() float64 {
+	a := m.m
+	return a[0][0]*(a[1][1]*a[2][2]-a[1][2]*a[2][1]) -
+		a[0][1]*(a[1][0]*a[2][2]-a[1][2]*a[2][0]) +
+		a[0][2]*(a[1][0]*a[2][1]-a[1][1]*a[2][0])
+}
+
+// normalMatrix returns the inverse transpose of the 3x3 part of the matrix (its cofactor matrix
+// divided by the determinant), which keeps normals perpendicular under non uniform scales.
+func (m mat44) normalMatrix() mat44 {
+	det := m.determinant3()
+	if det == 0 {
+		return m
+	}
+
+	a := m.m
+	mat := identityMatrix()
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			i1, i2 := (i+1)%3, (i+2)%3
+			j1, j2 := (j+1)%3, (j+2)%3
+			mat.m[i][j] = (a[i1][j1]*a[i2][j2] - a[i1][j2]*a[i2][j1]) / det
+		}
+	}
+
+	return mat
+}
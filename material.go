@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type ColorVector struct {
+	r, g, b float64
+}
+
+func NewColorVector(r, g, b float64) ColorVector {
+	return ColorVector{r, g, b}
+}
+
+func (c1 ColorVector) Add(c2 ColorVector) ColorVector {
+	return ColorVector{c1.r + c2.r, c1.g + c2.g, c1.b + c2.b}
+}
+
+func (c1 ColorVector) Mul(c2 ColorVector) ColorVector {
+	return ColorVector{c1.r * c2.r, c1.g * c2.g, c1.b * c2.b}
+}
+
+func (c ColorVector) Scale(k float64) ColorVector {
+	return ColorVector{c.r * k, c.g * k, c.b * k}
+}
+
+func (c ColorVector) IsZero() bool {
+	return c.r == 0 && c.g == 0 && c.b == 0
+}
+
+// Material holds the properties of a .mtl 'newmtl' block.
+type Material struct {
+	name string
+
+	ambient  ColorVector // Ka
+	diffuse  ColorVector // Kd
+	specular ColorVector // Ks
+	emissive ColorVector // Ke
+
+	shininess float64 // Ns
+	opacity   float64 // d, or 1 - Tr
+	illum     int     // Illumination model: 0 unlit, 1 diffuse only, 2 or more adds specular highlights
+
+	diffuseMap  *Texture // map_Kd, replaces the diffuse color when present
+	specularMap *Texture // map_Ks, multiplies the specular color
+	alphaMap    *Texture // map_d, multiplies the opacity
+	bumpMap     *Texture // map_Bump or bump, height map
+	normalMap   *Texture // norm, tangent space normal map
+
+	bumpMultiplier float64 // -bm option of the bump map
+}
+
+func NewMaterial(name string) *Material {
+	return &Material{
+		name: name,
+
+		ambient: NewColorVector(1, 1, 1),
+		diffuse: NewColorVector(0.8, 0.8, 0.8),
+
+		shininess: 1,
+		opacity:   1,
+		illum:     2,
+
+		bumpMultiplier: 1,
+	}
+}
+
+// Used by faces without any material
+var defaultMaterial = NewMaterial("")
+
+// Options of a texture map statement (e.g. 'map_Kd -clamp on -o 0 0 file.png'), and how many
+// values they take. Options with a negative count take up to that many numeric values.
+var textureMapOptions = map[string]int{
+	"-blendu": 1, "-blendv": 1, "-bm": 1, "-boost": 1, "-cc": 1, "-clamp": 1, "-imfchan": 1,
+	"-texres": 1, "-type": 1, "-mm": 2, "-o": -3, "-s": -3, "-t": -3,
+}
+
+// parseTextureMap splits the arguments of a texture map statement into its options and file name.
+func parseTextureMap(args []string) (string, map[string][]string) {
+	options := make(map[string][]string)
+
+	i := 0
+	for i < len(args) {
+		count, ok := textureMapOptions[args[i]]
+		if !ok {
+			break
+		}
+
+		option := args[i]
+		i++
+
+		values := []string{}
+		if count > 0 {
+			for n := 0; n < count && i < len(args); n++ {
+				values = append(values, args[i])
+				i++
+			}
+		} else {
+			for n := 0; n < -count && i < len(args); n++ {
+				if _, err := strconv.ParseFloat(args[i], 64); err != nil {
+					break
+				}
+				values = append(values, args[i])
+				i++
+			}
+		}
+
+		options[option] = values
+	}
+
+	// File names may contain spaces
+	return strings.Join(args[i:], " "), options
+}
+
+// parseColor reads 'Ka r g b' like statements. A single value is used for the three components.
+func parseColor(parts []string) (ColorVector, string, bool) {
+	values := parts[1:]
+	if len(values) > 0 && values[0] == "xyz" {
+		values = values[1:]
+	}
+	if len(values) != 1 && len(values) != 3 {
+		return ColorVector{}, parts[0], false
+	}
+
+	components := [3]float64{}
+	for i, value := range values {
+		num, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return ColorVector{}, value, false
+		}
+		components[i] = num
+	}
+
+	if len(values) == 1 {
+		return NewColorVector(components[0], components[0], components[0]), "", true
+	}
+
+	return NewColorVector(components[0], components[1], components[2]), "", true
+}
+
+// LoadMaterialLibrary loads the materials of an .mtl file (and their textures) into the map.
+func LoadMaterialLibrary(ctx context.Context, mtlFilename string, materials map[string]*Material) error {
+	basePath := filepath.Dir(mtlFilename)
+	bytes, err := os.ReadFile(mtlFilename)
+	if err != nil {
+		return err
+	}
+
+	// The same image is often used by several materials, load it only once
+	textures := make(map[string]*Texture)
+
+	var material *Material
+
+	for lineIdx, line := range strings.Split(string(bytes), "\n") {
+		// Checked on every line, as each one may load a texture
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		cleanLine := strings.TrimSpace(line)
+		if cleanLine == "" || strings.HasPrefix(cleanLine, "#") {
+			continue
+		}
+
+		parts := strings.Fields(cleanLine)
+
+		if parts[0] == "newmtl" {
+			if len(parts) < 2 {
+				return newParseError(mtlFilename, lineIdx, line, parts[0], "missing material name")
+			}
+
+			material = NewMaterial(parts[1])
+			materials[material.name] = material
+			continue
+		}
+
+		if material == nil {
+			continue // Statements before the first 'newmtl' have no material to apply to
+		}
+
+		switch parts[0] {
+		case "Ka", "Kd", "Ks", "Ke":
+			if len(parts) > 1 && parts[1] == "spectral" {
+				continue // Spectral curves are not supported
+			}
+
+			color, token, ok := parseColor(parts)
+			if !ok {
+				return newParseError(mtlFilename, lineIdx, line, token, "invalid color")
+			}
+
+			switch parts[0] {
+			case "Ka":
+				material.ambient = color
+			case "Kd":
+				material.diffuse = color
+			case "Ks":
+				material.specular = color
+			case "Ke":
+				material.emissive = color
+			}
+		case "Ns", "d", "Tr":
+			if len(parts) < 2 {
+				return newParseError(mtlFilename, lineIdx, line, parts[0], "missing value")
+			}
+
+			// 'd -halo 0.5' form
+			valueString := parts[len(parts)-1]
+			value, err := strconv.ParseFloat(valueString, 64)
+			if err != nil {
+				return newParseError(mtlFilename, lineIdx, line, valueString, "invalid value")
+			}
+
+			switch parts[0] {
+			case "Ns":
+				material.shininess = value
+			case "d":
+				material.opacity = value
+			case "Tr":
+				material.opacity = 1 - value
+			}
+		case "illum":
+			if len(parts) < 2 {
+				return newParseError(mtlFilename, lineIdx, line, parts[0], "missing illumination model")
+			}
+
+			illum, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return newParseError(mtlFilename, lineIdx, line, parts[1], "invalid illumination model")
+			}
+			material.illum = illum
+		case "map_Kd", "map_Ks", "map_d", "map_Bump", "map_bump", "bump", "norm":
+			texFileName, options := parseTextureMap(parts[1:])
+			if texFileName == "" {
+				return newParseError(mtlFilename, lineIdx, line, parts[0], "missing texture file")
+			}
+
+			texFilePath := filepath.Join(basePath, filepath.FromSlash(strings.ReplaceAll(texFileName, "\\", "/")))
+			texture, ok := textures[texFilePath]
+			if !ok {
+				texture, err = LoadTexture(texFilePath)
+				if err != nil {
+					parseErr := newParseError(mtlFilename, lineIdx, line, texFileName, "cannot load texture")
+					parseErr.Err = err
+					return parseErr
+				}
+				textures[texFilePath] = texture
+			}
+
+			// '-clamp on' stops the texture from repeating outside of the 0 to 1 coordinates
+			if clamp, ok := options["-clamp"]; ok && len(clamp) == 1 && clamp[0] == "on" {
+				texture = texture.WithWrap(WRAP_CLAMP, WRAP_CLAMP)
+			}
+
+			switch parts[0] {
+			case "map_Kd":
+				material.diffuseMap = texture
+			case "map_Ks":
+				material.specularMap = texture
+			case "map_d":
+				material.alphaMap = texture
+			case "map_Bump", "map_bump", "bump":
+				material.bumpMap = texture
+				if bm, ok := options["-bm"]; ok && len(bm) == 1 {
+					if value, err := strconv.ParseFloat(bm[0], 64); err == nil {
+						material.bumpMultiplier = value
+					}
+				}
+			case "norm":
+				material.normalMap = texture
+			}
+		}
+	}
+
+	return nil
+}
@@ -1,11 +1,390 @@
 package main
 
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Mesh is an indexed triangle mesh. The vertex attributes are stored once, in parallel arrays,
+// and shared by the triangles of its submeshes.
 type Mesh struct {
-	tris                         []Triangle
-	triangleAmount, vertexAmount int
+	positions [][3]float64
+	normals   []NormalVector
+	texCoords []TexVector
+	colors    []ColorVector // Empty when no vertex has a color
+
+	submeshes []Submesh
+
+	faceAmount, triangleAmount, vertexAmount int // Faces as read from the file, before triangulating them
+	unusedVertices                           int // Vertices of the file no face uses
 
 	// Lowest and highest vertice values (used to center and offset camera)
 	lowestX, highestX float64
 	lowestY, highestY float64
 	lowestZ, highestZ float64
 }
+
+// Submesh holds the triangles of a mesh sharing a material, as indices into its vertex arrays.
+type Submesh struct {
+	material     *Material
+	vertexColors bool    // Whether the vertex colors tint the material color
+	indices      []int32 // 3 per triangle
+}
+
+// MeshDecoder reads a model into a mesh. The filename is used in error messages and to find the
+// files referenced by the model (materials, textures, buffers), and may be empty. Decoders report
+// their progress with startPhase and reportProgress, and stop with the context error once it is
+// cancelled.
+type MeshDecoder func(ctx context.Context, r io.Reader, filename string) (*Mesh, error)
+
+type meshFormat struct {
+	name       string
+	extensions []string
+	magic      string
+	decode     MeshDecoder
+}
+
+// Formats in registration order, which is also the order of the file dialog filters
+var meshFormats []meshFormat
+
+var ErrUnknownMeshFormat = errors.New("unknown mesh format")
+
+// RegisterMeshFormat registers a model format for LoadMesh and DecodeMesh, and is meant to be
+// called from init functions. Extensions include the dot (e.g. ".obj"), and magic is the prefix
+// that identifies the format's files, where '?' matches any byte (empty if it has none).
+func RegisterMeshFormat(name string, extensions []string, magic string, decode MeshDecoder) {
+	lowerExtensions := make([]string, len(extensions))
+	for i, extension := range extensions {
+		lowerExtensions[i] = strings.ToLower(extension)
+	}
+
+	meshFormats = append(meshFormats, meshFormat{name, lowerExtensions, magic, decode})
+}
+
+func matchMagic(magic string, data []byte) bool {
+	if len(magic) != len(data) {
+		return false
+	}
+	for i := range data {
+		if magic[i] != '?' && magic[i] != data[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sniffMeshFormat returns the format whose magic the data starts with.
+func sniffMeshFormat(r *bufio.Reader) (meshFormat, bool) {
+	for _, format := range meshFormats {
+		if format.magic == "" {
+			continue
+		}
+
+		data, err := r.Peek(len(format.magic))
+		if err == nil && matchMagic(format.magic, data) {
+			return format, true
+		}
+	}
+
+	return meshFormat{}, false
+}
+
+func meshFormatByExtension(filename string) (meshFormat, bool) {
+	extension := strings.ToLower(filepath.Ext(filename))
+	for _, format := range meshFormats {
+		for _, formatExtension := range format.extensions {
+			if extension == formatExtension {
+				return format, true
+			}
+		}
+	}
+
+	return meshFormat{}, false
+}
+
+// DecodeMesh reads a model in any registered format, picked by its magic bytes or, for formats
+// without them, by the extension of the hint file name. The hint is also given to the decoder
+// to find the files referenced by the model.
+func DecodeMesh(ctx context.Context, r io.Reader, hint string) (*Mesh, error) {
+	reader := bufio.NewReader(r)
+
+	format, ok := sniffMeshFormat(reader)
+	if !ok {
+		format, ok = meshFormatByExtension(hint)
+	}
+	if !ok {
+		if extension := filepath.Ext(hint); extension != "" {
+			return nil, fmt.Errorf("%w '%s'", ErrUnknownMeshFormat, extension)
+		}
+		return nil, ErrUnknownMeshFormat
+	}
+
+	return format.decode(ctx, reader, hint)
+}
+
+// LoadMesh loads a model file in any registered format.
+func LoadMesh(ctx context.Context, filename string) (*Mesh, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	size := 0
+	if info, err := file.Stat(); err == nil {
+		size = int(info.Size())
+	}
+	startPhase(ctx, LOAD_READING, size)
+
+	return DecodeMesh(ctx, &progressReader{ctx: ctx, r: file}, filename)
+}
+
+// MeshEncoder writes a mesh in a model format. The filename is used to name the files written
+// next to the model (materials, textures), and may be empty to write the model alone.
+type MeshEncoder func(w io.Writer, mesh *Mesh, filename string, opts EncodeOptions) error
+
+type EncodeOptions struct {
+	ASCII bool // Text instead of binary, for the formats having both
+}
+
+type meshEncoder struct {
+	name       string
+	extensions []string
+	encode     MeshEncoder
+}
+
+// Encoders in registration order, which is also the order of the save dialog filters
+var meshEncoders []meshEncoder
+
+// RegisterMeshEncoder registers a model format for SaveMesh, and is meant to be called from init
+// functions. Extensions include the dot (e.g. ".obj").
+func RegisterMeshEncoder(name string, extensions []string, encode MeshEncoder) {
+	lowerExtensions := make([]string, len(extensions))
+	for i, extension := range extensions {
+		lowerExtensions[i] = strings.ToLower(extension)
+	}
+
+	meshEncoders = append(meshEncoders, meshEncoder{name, lowerExtensions, encode})
+}
+
+func meshEncoderByExtension(filename string) (meshEncoder, bool) {
+	extension := strings.ToLower(filepath.Ext(filename))
+	for _, encoder := range meshEncoders {
+		for _, encoderExtension := range encoder.extensions {
+			if extension == encoderExtension {
+				return encoder, true
+			}
+		}
+	}
+
+	return meshEncoder{}, false
+}
+
+// SaveMesh writes the mesh to a file in the registered format of its extension, along with the
+// files it references.
+func SaveMesh(filename string, mesh *Mesh, opts EncodeOptions) error {
+	encoder, ok := meshEncoderByExtension(filename)
+	if !ok {
+		return fmt.Errorf("%w '%s'", ErrUnknownMeshFormat, filepath.Ext(filename))
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	if err := encoder.encode(w, mesh, filename, opts); err != nil {
+		file.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// MeshExtensions returns the file extensions of every registered format.
+func MeshExtensions() []string {
+	extensions := []string{}
+	for _, format := range meshFormats {
+		extensions = append(extensions, format.extensions...)
+	}
+
+	return extensions
+}
+
+// vertex returns the vertex i of the mesh, in model coordinates.
+func (m *Mesh) vertex(i int32) Vector4 {
+	p := m.positions[i]
+	v := NewVector4(p[0], p[1], p[2], 1)
+	v.normVec = m.normals[i]
+	v.texVec = m.texCoords[i]
+	if len(m.colors) > 0 {
+		v.color = m.colors[i]
+	}
+
+	return v
+}
+
+// Triangles returns an iterator over the triangles of every submesh, called as in
+// 'mesh.Triangles()(func(t Triangle) bool { ... })', where returning false stops it.
+func (m *Mesh) Triangles() func(yield func(Triangle) bool) {
+	return func(yield func(Triangle) bool) {
+		for s := range m.submeshes {
+			submesh := &m.submeshes[s]
+			for i := 0; i+2 < len(submesh.indices); i += 3 {
+				t := Triangle{material: submesh.material, vertexColors: submesh.vertexColors}
+				for j := range t.vecs {
+					t.vecs[j] = m.vertex(submesh.indices[i+j])
+				}
+
+				if !yield(t) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// hasTexCoords returns whether any vertex has texture coordinates.
+func (m *Mesh) hasTexCoords() bool {
+	for _, t := range m.texCoords {
+		if t.u != 0 || t.v != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// boundingSphere returns the center and radius of the sphere around the mesh bounds, or a unit
+// sphere for empty meshes.
+func (m *Mesh) boundingSphere() (Vector4, float64) {
+	if len(m.positions) == 0 {
+		return NewVector4(0, 0, 0, 1), 1
+	}
+
+	center := NewVector4((m.lowestX+m.highestX)/2, (m.lowestY+m.highestY)/2, (m.lowestZ+m.highestZ)/2, 1)
+	radius := NewVector4(m.highestX-m.lowestX, m.highestY-m.lowestY, m.highestZ-m.lowestZ, 0).Len() / 2
+
+	return center, math.Max(radius, 1e-6)
+}
+
+// isPointCloud returns whether the mesh has vertices but no faces, so it is drawn as points.
+func (m *Mesh) isPointCloud() bool {
+	return len(m.submeshes) == 0 && len(m.positions) > 0
+}
+
+// faceNormal returns the normal of the triangle with the given vertices, facing the side where
+// they go counterclockwise.
+func (m *Mesh) faceNormal(i0, i1, i2 int32) NormalVector {
+	p0, p1, p2 := m.positions[i0], m.positions[i1], m.positions[i2]
+	a := NewVector4(p1[0]-p0[0], p1[1]-p0[1], p1[2]-p0[2], 0)
+	b := NewVector4(p2[0]-p0[0], p2[1]-p0[1], p2[2]-p0[2], 0)
+	n := a.CrossProduct(b)
+
+	return NewNormalVector(n.x, n.y, n.z).Normalise()
+}
+
+// updateBounds computes the lowest and highest vertex coordinates.
+func (m *Mesh) updateBounds() {
+	m.lowestX, m.lowestY, m.lowestZ = math.MaxFloat64, math.MaxFloat64, math.MaxFloat64
+	m.highestX, m.highestY, m.highestZ = -math.MaxFloat64, -math.MaxFloat64, -math.MaxFloat64
+
+	for _, p := range m.positions {
+		m.lowestX = math.Min(m.lowestX, p[0])
+		m.lowestY = math.Min(m.lowestY, p[1])
+		m.lowestZ = math.Min(m.lowestZ, p[2])
+		m.highestX = math.Max(m.highestX, p[0])
+		m.highestY = math.Max(m.highestY, p[1])
+		m.highestZ = math.Max(m.highestZ, p[2])
+	}
+}
+
+// meshBuilder assembles an indexed mesh for the decoders, which add the vertices (sharing them
+// between triangles as far as their format allows) and then the triangles using them.
+type meshBuilder struct {
+	mesh      Mesh
+	submeshes map[submeshKey]int // Index of the submesh of each material
+
+	smoothGroups []int32 // Of the vertices without a normal, to generate it. -1 for the rest
+	triangles    int
+}
+
+type submeshKey struct {
+	material     *Material
+	vertexColors bool
+}
+
+// addVertex adds a vertex with the position, normal, texture coordinates and color of v. Vertices
+// without a normal get one generated by build, averaged over the faces sharing their position in
+// the same smoothing group. Group 0 gives them the normal of their face instead, so those must
+// not be shared between faces.
+func (b *meshBuilder) addVertex(v Vector4, smoothGroup int) int32 {
+	m := &b.mesh
+	i := int32(len(m.positions))
+
+	m.positions = append(m.positions, [3]float64{v.x, v.y, v.z})
+	m.normals = append(m.normals, v.normVec)
+	m.texCoords = append(m.texCoords, v.texVec)
+
+	if v.color != (ColorVector{}) && m.colors == nil {
+		m.colors = make([]ColorVector, i, cap(m.positions))
+	}
+	if m.colors != nil {
+		m.colors = append(m.colors, v.color)
+	}
+
+	group := int32(-1)
+	if v.normVec.IsZero() {
+		group = int32(smoothGroup)
+	}
+	if group >= 0 && b.smoothGroups == nil {
+		b.smoothGroups = make([]int32, i, cap(m.positions))
+		for j := range b.smoothGroups {
+			b.smoothGroups[j] = -1
+		}
+	}
+	if b.smoothGroups != nil {
+		b.smoothGroups = append(b.smoothGroups, group)
+	}
+
+	return i
+}
+
+func (b *meshBuilder) addTriangle(material *Material, vertexColors bool, i0, i1, i2 int32) {
+	key := submeshKey{material, vertexColors}
+	s, ok := b.submeshes[key]
+	if !ok {
+		if b.submeshes == nil {
+			b.submeshes = make(map[submeshKey]int)
+		}
+
+		s = len(b.mesh.submeshes)
+		b.submeshes[key] = s
+		b.mesh.submeshes = append(b.mesh.submeshes, Submesh{material: material, vertexColors: vertexColors})
+	}
+
+	b.mesh.submeshes[s].indices = append(b.mesh.submeshes[s].indices, i0, i1, i2)
+	b.triangles++
+}
+
+// build generates the missing normals and returns the mesh, with its bounds.
+func (b *meshBuilder) build() *Mesh {
+	b.generateNormals()
+	b.mesh.updateBounds()
+
+	return &b.mesh
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVertexColorOfFirstVertex(t *testing.T) {
+	ply := `ply
+format ascii 1.0
+element vertex 3
+property float x
+property float y
+property float z
+property uchar red
+property uchar green
+property uchar blue
+element face 1
+property list uchar int vertex_indices
+end_header
+0 0 0 255 0 0
+1 0 0 0 255 0
+0 1 0 0 0 255
+3 0 1 2
+`
+	mesh, err := DecodeMesh(context.Background(), strings.NewReader(ply), "colors.ply")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ColorVector{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	if len(mesh.colors) != len(want) {
+		t.Fatalf("got %d colors, want %d", len(mesh.colors), len(want))
+	}
+	for i, c := range want {
+		if mesh.colors[i] != c {
+			t.Errorf("color %d is %v, want %v", i, mesh.colors[i], c)
+		}
+	}
+}
+
+func TestUnusedVerticesOfPly(t *testing.T) {
+	ply := `ply
+format ascii 1.0
+element vertex 4
+property float x
+property float y
+property float z
+element face 1
+property list uchar int vertex_indices
+end_header
+0 0 0
+1 0 0
+0 1 0
+5 5 5
+3 0 1 2
+`
+	mesh, err := DecodeMesh(context.Background(), strings.NewReader(ply), "unused.ply")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := AnalyzeMesh(context.Background(), mesh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.UnusedVertices != 1 {
+		t.Errorf("got %d unused vertices, want 1", report.UnusedVertices)
+	}
+}
+
+func TestStlWithoutFacets(t *testing.T) {
+	// Binary file whose header starts with solid, cut short after its first facet
+	binary := make([]byte, STL_HEADER_SIZE+4+STL_FACET_SIZE)
+	copy(binary, "solid exported")
+	binary[STL_HEADER_SIZE] = 2
+
+	files := map[string]string{
+		"empty.stl":     "solid\n",
+		"truncated.stl": string(binary),
+	}
+	for name, data := range files {
+		_, err := DecodeMesh(context.Background(), strings.NewReader(data), name)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%s: got error %v, want a parse error", name, err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+// generateNormals fills the missing vertex normals by averaging the normals of the faces that
+// share each vertex position, weighted by their area. Only faces in the same smoothing group are
+// averaged together, and faces with smoothing turned off (group 0) get their face normal.
+func (b *meshBuilder) generateNormals() {
+	if b.smoothGroups == nil {
+		return
+	}
+
+	type vertexKey struct {
+		x, y, z float64
+		group   int32
+	}
+
+	m := &b.mesh
+	sums := make(map[vertexKey]NormalVector)
+	position := func(i int32) Vector4 {
+		p := m.positions[i]
+		return NewVector4(p[0], p[1], p[2], 1)
+	}
+
+	for _, submesh := range m.submeshes {
+		for i := 0; i+2 < len(submesh.indices); i += 3 {
+			corners := submesh.indices[i : i+3]
+
+			// The group of the face, from its vertices without a normal
+			group := int32(-1)
+			for _, c := range corners {
+				group = max(group, b.smoothGroups[c])
+			}
+			if group < 0 {
+				continue
+			}
+
+			// The cross product length is twice the triangle area, so the sum is already area weighted
+			v0, v1, v2 := position(corners[0]), position(corners[1]), position(corners[2])
+			normal := v1.Sub(v0).CrossProduct(v2.Sub(v0))
+			faceNormal := NewNormalVector(normal.x, normal.y, normal.z)
+
+			for _, c := range corners {
+				if group == 0 {
+					if b.smoothGroups[c] == 0 {
+						m.normals[c] = faceNormal.Normalise()
+					}
+					continue
+				}
+
+				p := m.positions[c]
+				key := vertexKey{p[0], p[1], p[2], group}
+				sums[key] = sums[key].Add(faceNormal)
+			}
+		}
+	}
+
+	for i, group := range b.smoothGroups {
+		if group > 0 {
+			p := m.positions[i]
+			m.normals[i] = sums[vertexKey{p[0], p[1], p[2], group}].Normalise()
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterMeshEncoder("OBJ", []string{".obj"}, WriteObj)
+}
+
+// WriteObj writes the mesh as an .obj model. Unless filename is empty, its materials are written
+// to an .mtl file of the same name, and their textures copied next to it.
+func WriteObj(w io.Writer, mesh *Mesh, filename string, opts EncodeOptions) error {
+	if _, err := fmt.Fprintln(w, "# Exported by 3d-viewer"); err != nil {
+		return err
+	}
+
+	var materialNames map[*Material]string
+	if filename != "" && hasMaterials(mesh) {
+		mtlFilename := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".mtl"
+		names, err := writeMtlFile(mtlFilename, mesh)
+		if err != nil {
+			return err
+		}
+
+		materialNames = names
+		if _, err := fmt.Fprintf(w, "mtllib %s\n", filepath.Base(mtlFilename)); err != nil {
+			return err
+		}
+	}
+
+	// OBJ indexes each attribute separately, so the values repeated by split vertices are
+	// written once
+	hasTexCoords := mesh.hasTexCoords()
+
+	type objPosition struct {
+		position [3]float64
+		color    ColorVector
+	}
+	positions := make(map[objPosition]int)
+	texCoords := make(map[[2]float64]int)
+	normals := make(map[NormalVector]int)
+	indices := make([][3]int, len(mesh.positions))
+
+	buffer := []byte{}
+	for i, p := range mesh.positions {
+		key := objPosition{position: p}
+		if len(mesh.colors) > 0 {
+			key.color = mesh.colors[i]
+		}
+
+		index, ok := positions[key]
+		if !ok {
+			index = len(positions) + 1
+			positions[key] = index
+
+			buffer = appendObjFloats(append(buffer[:0], 'v'), p[:]...)
+			if len(mesh.colors) > 0 {
+				buffer = appendObjFloats(buffer, key.color.r, key.color.g, key.color.b)
+			}
+			if _, err := w.Write(append(buffer, '\n')); err != nil {
+				return err
+			}
+		}
+		indices[i][0] = index
+	}
+
+	if hasTexCoords {
+		for i, t := range mesh.texCoords {
+			index, ok := texCoords[[2]float64{t.u, t.v}]
+			if !ok {
+				index = len(texCoords) + 1
+				texCoords[[2]float64{t.u, t.v}] = index
+				if _, err := w.Write(append(appendObjFloats(append(buffer[:0], "vt"...), t.u, t.v), '\n')); err != nil {
+					return err
+				}
+			}
+			indices[i][1] = index
+		}
+	}
+
+	for i, n := range mesh.normals {
+		index, ok := normals[n]
+		if !ok {
+			index = len(normals) + 1
+			normals[n] = index
+			if _, err := w.Write(append(appendObjFloats(append(buffer[:0], "vn"...), n.x, n.y, n.z), '\n')); err != nil {
+				return err
+			}
+		}
+		indices[i][2] = index
+	}
+
+	for _, submesh := range mesh.submeshes {
+		if name, ok := materialNames[submesh.material]; ok {
+			if _, err := fmt.Fprintf(w, "usemtl %s\n", name); err != nil {
+				return err
+			}
+		}
+
+		for i := 0; i+2 < len(submesh.indices); i += 3 {
+			buffer = append(buffer[:0], 'f')
+			for _, v := range submesh.indices[i : i+3] {
+				index := indices[v]
+				buffer = strconv.AppendInt(append(buffer, ' '), int64(index[0]), 10)
+				buffer = append(buffer, '/')
+				if hasTexCoords {
+					buffer = strconv.AppendInt(buffer, int64(index[1]), 10)
+				}
+				buffer = strconv.AppendInt(append(buffer, '/'), int64(index[2]), 10)
+			}
+			if _, err := w.Write(append(buffer, '\n')); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// appendObjFloats appends the values separated by spaces, with the shortest text reading back as
+// the same float32, which is the precision of every supported format.
+func appendObjFloats(buffer []byte, values ...float64) []byte {
+	for _, value := range values {
+		buffer = strconv.AppendFloat(append(buffer, ' '), value, 'g', -1, 32)
+	}
+
+	return buffer
+}
+
+func hasMaterials(mesh *Mesh) bool {
+	for _, submesh := range mesh.submeshes {
+		if submesh.material != defaultMaterial {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeMtlFile writes the materials of the mesh and copies their textures next to the file,
+// returning the unique name given to each material.
+func writeMtlFile(mtlFilename string, mesh *Mesh) (map[*Material]string, error) {
+	file, err := os.Create(mtlFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	bw := bufio.NewWriter(file)
+	fmt.Fprintln(bw, "# Exported by 3d-viewer")
+
+	names := make(map[*Material]string)
+	used := make(map[string]bool)
+	textures := textureWriter{dir: filepath.Dir(mtlFilename), prefix: strings.TrimSuffix(filepath.Base(mtlFilename), ".mtl"), names: make(map[*textureLevel]string), used: make(map[string]bool)}
+
+	for _, submesh := range mesh.submeshes {
+		m := submesh.material
+		if _, ok := names[m]; ok {
+			continue
+		}
+
+		// Material names can't have spaces, and must be unique
+		base := strings.Join(strings.Fields(m.name), "_")
+		if base == "" {
+			base = "material"
+		}
+		name := base
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s_%d", base, n)
+		}
+		names[m] = name
+		used[name] = true
+
+		fmt.Fprintf(bw, "\nnewmtl %s\n", name)
+		writeMtlColor(bw, "Ka", m.ambient)
+		writeMtlColor(bw, "Kd", m.diffuse)
+		writeMtlColor(bw, "Ks", m.specular)
+		if !m.emissive.IsZero() {
+			writeMtlColor(bw, "Ke", m.emissive)
+		}
+		fmt.Fprintf(bw, "Ns %g\nd %g\nillum %d\n", m.shininess, m.opacity, m.illum)
+
+		maps := []struct {
+			statement string
+			texture   *Texture
+		}{
+			{"map_Kd", m.diffuseMap}, {"map_Ks", m.specularMap}, {"map_d", m.alphaMap}, {"map_Bump", m.bumpMap}, {"norm", m.normalMap},
+		}
+		for _, textureMap := range maps {
+			if textureMap.texture == nil {
+				continue
+			}
+
+			textureName, err := textures.write(textureMap.texture)
+			if err != nil {
+				return nil, err
+			}
+
+			options := ""
+			if textureMap.texture.wrapU == WRAP_CLAMP && textureMap.texture.wrapV == WRAP_CLAMP {
+				options += " -clamp on"
+			}
+			if textureMap.statement == "map_Bump" && m.bumpMultiplier != 1 {
+				options += fmt.Sprintf(" -bm %g", m.bumpMultiplier)
+			}
+			fmt.Fprintf(bw, "%s%s %s\n", textureMap.statement, options, textureName)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	return names, file.Close()
+}
+
+func writeMtlColor(w io.Writer, statement string, c ColorVector) {
+	fmt.Fprintf(w, "%s %g %g %g\n", statement, c.r, c.g, c.b)
+}
+
+// textureWriter writes the textures of the materials into a folder, once each.
+type textureWriter struct {
+	dir, prefix string
+	names       map[*textureLevel]string // By image data, shared by the copies with other wrap modes
+	used        map[string]bool
+}
+
+// write copies the texture file into the folder, or saves it as a PNG image when it was embedded
+// in the model, and returns its file name.
+func (tw *textureWriter) write(t *Texture) (string, error) {
+	if name, ok := tw.names[&t.levels[0]]; ok {
+		return name, nil
+	}
+
+	name := fmt.Sprintf("%s_texture.png", tw.prefix)
+	if t.source != "" {
+		name = strings.ReplaceAll(filepath.Base(t.source), " ", "_")
+	}
+
+	extension := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, extension)
+	for n := 2; tw.taken(name, t.source); n++ {
+		name = fmt.Sprintf("%s_%d%s", stem, n, extension)
+	}
+	tw.used[name] = true
+	tw.names[&t.levels[0]] = name
+
+	path := filepath.Join(tw.dir, name)
+	if t.source != "" {
+		return name, copyFile(path, t.source)
+	}
+
+	return name, writeTexturePng(path, t)
+}
+
+// taken tells whether the name is already used by another texture of the export, or by a file in
+// the folder that isn't the source of the texture itself, so existing files are never overwritten.
+func (tw *textureWriter) taken(name, source string) bool {
+	if tw.used[name] {
+		return true
+	}
+
+	info, err := os.Stat(filepath.Join(tw.dir, name))
+	if err != nil {
+		return false
+	}
+	if source != "" {
+		if srcInfo, err := os.Stat(source); err == nil && os.SameFile(info, srcInfo) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// copyFile copies the file at src to dst, unless both are the same file.
+func copyFile(dst, src string) error {
+	if srcInfo, err := os.Stat(src); err == nil {
+		if dstInfo, err := os.Stat(dst); err == nil && os.SameFile(srcInfo, dstInfo) {
+			return nil
+		}
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dst, data, 0o644)
+}
+
+func writeTexturePng(filename string, t *Texture) error {
+	base := t.levels[0]
+	img := image.NewRGBA(image.Rect(0, 0, base.w, base.h))
+	for i, c := range base.data {
+		img.Pix[i*4], img.Pix[i*4+1], img.Pix[i*4+2], img.Pix[i*4+3] = c.R, c.G, c.B, c.A
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
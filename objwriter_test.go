@@ -0,0 +1,33 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTextureWriterKeepsExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "model_texture.png")
+	if err := os.WriteFile(existing, []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tw := textureWriter{dir: dir, prefix: "model", names: make(map[*textureLevel]string), used: make(map[string]bool)}
+	name, err := tw.write(NewTexture(image.NewRGBA(image.Rect(0, 0, 2, 2))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "model_texture_2.png" {
+		t.Errorf("got texture name %q, want model_texture_2.png", name)
+	}
+
+	data, err := os.ReadFile(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "keep" {
+		t.Error("existing file was overwritten")
+	}
+}
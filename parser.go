@@ -1,272 +1,526 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"math"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
-
-	"github.com/ncruces/zenity"
 )
 
-func parseVector(parts []string) Vector4 {
-	vertice := Vector4{0, 0, 0, 1, -1, NewTexVector(0, 0, 0)}
+const MAX_OBJ_LINE int = 16 * 1024 * 1024 // Longest line of an .obj file, in bytes
 
-	for i := 1; i < 4; i++ {
-		num, err := strconv.ParseFloat(parts[i], 32)
-		if err != nil {
-			log.Fatalf("Error parsing the vertice float '%s'", parts[1])
+// ParseError describes a malformed record found while loading a model or material file.
+type ParseError struct {
+	File  string
+	Line  int // 1-based, 0 when the error is not tied to a line
+	Col   int // 1-based column of Token, 0 when unknown
+	Token string
+	Msg   string
+	Err   error // Underlying error, if any
+}
+
+func (e *ParseError) Error() string {
+	location := e.File
+	if e.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, e.Line)
+		if e.Col > 0 {
+			location = fmt.Sprintf("%s:%d", location, e.Col)
 		}
+	}
 
-		num32 := float64(num)
+	msg := e.Msg
+	if e.Token != "" {
+		msg = fmt.Sprintf("%s '%s'", msg, e.Token)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Err)
+	}
 
-		switch i {
-		case 1:
-			vertice.x = num32
-		case 2:
-			vertice.y = num32
-		case 3:
-			vertice.z = num32
-		}
+	return fmt.Sprintf("%s: %s", location, msg)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// newParseError creates a ParseError for the token found in the given line (0-based index).
+func newParseError(file string, lineIdx int, line, token, msg string) *ParseError {
+	col := 0
+	if token != "" {
+		col = strings.Index(line, token) + 1
 	}
 
-	return vertice
+	return &ParseError{File: file, Line: lineIdx + 1, Col: col, Token: token, Msg: msg}
 }
 
-func ParseObj(filename string) *Mesh {
-	mesh := Mesh{}
+func init() {
+	RegisterMeshFormat("OBJ", []string{".obj"}, "", ParseObj)
+}
 
-	bytes, err := os.ReadFile(filename)
+// objParser holds the state of an .obj file read line by line.
+type objParser struct {
+	ctx      context.Context
+	filename string
+	phase    LoadPhase
+
+	vertices     [][3]float64
+	usedVertices []bool
+	texVertices  []TexVector
+	normals      []NormalVector
+
+	materials   map[string]*Material
+	libraries   map[string]bool // .mtl files already loaded
+	material    *Material
+	smoothGroup int
+
+	builder      meshBuilder
+	meshVertices map[objVertexKey]int32 // Mesh vertex of each combination of indices used by faces
+	faceAmount   int
+
+	fields         [][]byte  // Reused between lines
+	polygon        []Vector4 // Reused between faces
+	polygonIndices []int32
+}
 
-	if err != nil {
-		zenity.Error(fmt.Sprintf("Error loading the obj file.\n%s", err), zenity.Title("OBJ load error"), zenity.ErrorIcon)
-		panic(err)
+// The 0-based indices of a face vertex, -1 when missing. Vertices without a normal are only
+// shared within a smoothing group, as it changes their generated normal.
+type objVertexKey struct {
+	v, vt, vn   int32
+	smoothGroup int32
+}
+
+// ParseObj reads an .obj model (and its .mtl materials and textures, if any, found relative to
+// filename) into a mesh, in a single pass over the file. Malformed records are reported as a
+// *ParseError.
+func ParseObj(ctx context.Context, r io.Reader, filename string) (*Mesh, error) {
+	p := objParser{
+		ctx:       ctx,
+		filename:  filename,
+		phase:     LOAD_READING,
+		materials: make(map[string]*Material),
+		libraries: make(map[string]bool),
+		material:  defaultMaterial,
+
+		meshVertices: make(map[objVertexKey]int32),
+
+		// Without any 's' statement, the whole model is smoothed as a single group
+		smoothGroup: 1,
 	}
 
-	// Load .mtl and create a dictionary of: materialName - textureImage
-	mtlTex := GetMtlTex(bytes, filename)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), MAX_OBJ_LINE)
 
-	// Get the texture vertices
-	texVertices := GetTexVerts(bytes)
+	for lineIdx := 0; scanner.Scan(); lineIdx++ {
+		if lineIdx%PROGRESS_INTERVAL == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
 
-	// Get the model vertices
-	vertices, lowests, highests := GetVerts(bytes)
+		if err := p.parseLine(scanner.Bytes(), lineIdx); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, &ParseError{File: filename, Msg: fmt.Sprintf("line longer than %d bytes", MAX_OBJ_LINE)}
+		}
+		return nil, err
+	}
 
-	mesh.lowestX = lowests[0]
-	mesh.lowestY = lowests[1]
-	mesh.lowestZ = lowests[2]
-	mesh.highestX = highests[0]
-	mesh.highestY = highests[1]
-	mesh.highestZ = highests[2]
+	startPhase(ctx, LOAD_SMOOTHING, 0)
+	mesh := p.builder.build()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	// Get the triangles, using previous values
-	triangles := GetTriangles(bytes, mtlTex, vertices, texVertices)
+	mesh.vertexAmount = len(p.vertices)
+	mesh.unusedVertices = len(p.vertices) - countTrue(p.usedVertices)
+	mesh.faceAmount = p.faceAmount
+	mesh.triangleAmount = p.builder.triangles
 
-	mesh.tris = triangles
+	return mesh, nil
+}
 
-	mesh.vertexAmount = len(vertices)
-	mesh.triangleAmount = len(triangles)
+// setPhase reports the kind of record being read, when it changes.
+func (p *objParser) setPhase(phase LoadPhase) {
+	if phase != p.phase {
+		p.phase = phase
+		setPhase(p.ctx, phase)
+	}
+}
 
-	return &mesh
+func (p *objParser) error(lineIdx int, line, token []byte, msg string) error {
+	return newParseError(p.filename, lineIdx, string(line), string(token), msg)
 }
 
-func GetTexVerts(bytes []byte) []TexVector {
-	texVerts := []TexVector{}
+func (p *objParser) parseLine(line []byte, lineIdx int) error {
+	p.fields = splitFields(p.fields[:0], line)
+	fields := p.fields
+	if len(fields) == 0 {
+		return nil
+	}
+
+	switch string(fields[0]) {
+	case "v":
+		p.setPhase(LOAD_VERTICES)
 
-	for _, line := range strings.Split(string(bytes), "\n") {
-		cleanLine := strings.TrimSpace(line)
-		if cleanLine == "" {
-			continue
+		vertex, token, msg := parseCoordinates(fields)
+		if msg != "" {
+			return p.error(lineIdx, line, token, msg)
 		}
 
-		parts := strings.Fields(cleanLine)
+		p.vertices = append(p.vertices, vertex)
+		p.usedVertices = append(p.usedVertices, false)
+	case "vt":
+		p.setPhase(LOAD_UVS)
 
-		if parts[0] == "vt" {
-			texVertice := NewTexVector(0, 0, 0)
-			for i := 1; i < 3; i++ {
-				num, err := strconv.ParseFloat(parts[i], 32)
-				if err != nil {
-					zenity.Error(fmt.Sprintf("Error while parsing a texture vertice.\n%s", err), zenity.Title("Texture parsing error"), zenity.ErrorIcon)
-					panic(err)
-				}
+		if len(fields) < 2 {
+			return p.error(lineIdx, line, fields[0], "missing texture coordinates")
+		}
 
-				num32 := float64(num)
+		texVertex := NewTexVector(0, 0, 0)
+		for i := 1; i < 3 && i < len(fields); i++ { // The v coordinate is optional
+			num, ok := parseFloat32(fields[i])
+			if !ok {
+				return p.error(lineIdx, line, fields[i], "invalid texture coordinate")
+			}
 
-				switch i {
-				case 1:
-					texVertice.u = num32
-				case 2:
-					texVertice.v = num32
-				}
+			if i == 1 {
+				texVertex.u = num
+			} else {
+				texVertex.v = num
+			}
+		}
+
+		p.texVertices = append(p.texVertices, texVertex)
+	case "vn":
+		p.setPhase(LOAD_NORMALS)
+
+		normal, token, msg := parseCoordinates(fields)
+		if msg != "" {
+			return p.error(lineIdx, line, token, msg)
+		}
+
+		p.normals = append(p.normals, NewNormalVector(normal[0], normal[1], normal[2]))
+	case "f":
+		p.setPhase(LOAD_FACES)
+		return p.parseFace(fields, lineIdx, line)
+	case "usemtl":
+		p.material = defaultMaterial
+		if len(fields) > 1 {
+			if material, ok := p.materials[string(fields[1])]; ok {
+				p.material = material
 			}
+		}
+	case "s":
+		if len(fields) < 2 {
+			return p.error(lineIdx, line, fields[0], "missing smoothing group")
+		}
+
+		if string(fields[1]) == "off" {
+			p.smoothGroup = 0
+		} else {
+			group, ok := parseIndex(fields[1])
+			if !ok || group < 0 {
+				return p.error(lineIdx, line, fields[1], "invalid smoothing group")
+			}
+			p.smoothGroup = group
+		}
+	case "mtllib":
+		// The library name is the rest of the line, as it may contain spaces
+		name := string(bytes.TrimSpace(bytes.TrimSpace(line)[len("mtllib"):]))
+		if name == "" {
+			return nil
+		}
 
-			texVerts = append(texVerts, texVertice)
+		mtlFilename := filepath.Join(filepath.Dir(p.filename), name)
+		if p.libraries[mtlFilename] {
+			return nil
 		}
+		p.libraries[mtlFilename] = true
+
+		p.setPhase(LOAD_TEXTURES)
+		return LoadMaterialLibrary(p.ctx, mtlFilename, p.materials)
 	}
 
-	return texVerts
+	return nil
 }
 
-func GetVerts(bytes []byte) ([]Vector4, [3]float64, [3]float64) {
-	verts := []Vector4{}
+// parseFace reads a polygon, splitting it into triangles. Vertices can be 'v', 'v/vt', 'v/vt/vn'
+// or 'v//vn', where negative indices are relative to the elements defined so far.
+func (p *objParser) parseFace(fields [][]byte, lineIdx int, line []byte) error {
+	if len(fields) < 4 {
+		return p.error(lineIdx, line, fields[0], "a face needs at least 3 vertices")
+	}
 
-	lowests := [3]float64{math.MaxFloat64, math.MaxFloat64, math.MaxFloat64}
-	highests := [3]float64{-math.MaxFloat64, -math.MaxFloat64, -math.MaxFloat64}
+	p.polygon = p.polygon[:0]
+	p.polygonIndices = p.polygonIndices[:0]
 
-	for _, line := range strings.Split(string(bytes), "\n") {
-		cleanLine := strings.TrimSpace(line)
-		if cleanLine == "" {
-			continue
+	for _, field := range fields[1:] {
+		var parts [3][]byte
+		count := 0
+		for start := 0; ; count++ {
+			if count == len(parts) {
+				return p.error(lineIdx, line, field, "invalid face vertex")
+			}
+
+			end := bytes.IndexByte(field[start:], '/')
+			if end < 0 {
+				parts[count] = field[start:]
+				count++
+				break
+			}
+			parts[count] = field[start : start+end]
+			start += end + 1
 		}
 
-		parts := strings.Fields(cleanLine)
+		vIndex, err := resolveIndex(parts[0], len(p.vertices))
+		if err != nil {
+			return p.error(lineIdx, line, field, fmt.Sprintf("vertex index %s", err))
+		}
 
-		if parts[0] == "v" {
-			vertice := parseVector(parts)
+		position := p.vertices[vIndex]
+		p.usedVertices[vIndex] = true
+		vertex := NewVector4(position[0], position[1], position[2], 1)
+		key := objVertexKey{int32(vIndex), -1, -1, 0}
 
-			if vertice.x < lowests[0] {
-				lowests[0] = vertice.x
-			}
-			if vertice.y < lowests[1] {
-				lowests[1] = vertice.y
-			}
-			if vertice.z < lowests[2] {
-				lowests[2] = vertice.z
+		if count > 1 && len(parts[1]) > 0 {
+			vTexIndex, err := resolveIndex(parts[1], len(p.texVertices))
+			if err != nil {
+				return p.error(lineIdx, line, field, fmt.Sprintf("texture vertex index %s", err))
 			}
 
-			if vertice.x > highests[0] {
-				highests[0] = vertice.x
-			}
-			if vertice.y > highests[1] {
-				highests[1] = vertice.y
-			}
-			if vertice.z > highests[2] {
-				highests[2] = vertice.z
+			vertex.texVec = p.texVertices[vTexIndex]
+			key.vt = int32(vTexIndex)
+		}
+
+		if count > 2 && len(parts[2]) > 0 {
+			vNormIndex, err := resolveIndex(parts[2], len(p.normals))
+			if err != nil {
+				return p.error(lineIdx, line, field, fmt.Sprintf("normal index %s", err))
 			}
 
-			verts = append(verts, vertice)
+			vertex.normVec = p.normals[vNormIndex]
+			key.vn = int32(vNormIndex)
 		}
+		if key.vn < 0 || vertex.normVec.IsZero() {
+			key.smoothGroup = int32(p.smoothGroup)
+		}
+
+		p.polygon = append(p.polygon, vertex)
+		p.polygonIndices = append(p.polygonIndices, p.meshVertex(vertex, key))
 	}
 
-	return verts, lowests, highests
+	if len(p.polygon) == 3 {
+		p.builder.addTriangle(p.material, false, p.polygonIndices[0], p.polygonIndices[1], p.polygonIndices[2])
+	} else {
+		for _, idx := range TriangulatePolygon(p.polygon) {
+			p.builder.addTriangle(p.material, false, p.polygonIndices[idx[0]], p.polygonIndices[idx[1]], p.polygonIndices[idx[2]])
+		}
+	}
+
+	p.faceAmount++
+
+	return nil
 }
 
-var lastTexture *Texture
+// meshVertex returns the mesh vertex of the face vertex, adding it the first time its indices are
+// used. Vertices getting the normal of their face (no normal in smoothing group 0) are not shared.
+func (p *objParser) meshVertex(vertex Vector4, key objVertexKey) int32 {
+	if key.smoothGroup == 0 && vertex.normVec.IsZero() {
+		return p.builder.addVertex(vertex, 0)
+	}
 
-func GetMtlTex(bytes []byte, objFilename string) map[string]*Texture {
-	basePath := filepath.Dir(objFilename)
-	filename := ""
-	for _, line := range strings.Split(string(bytes), "\n") {
-		cleanLine := strings.TrimSpace(line)
-		if cleanLine == "" {
-			continue
-		}
+	i, ok := p.meshVertices[key]
+	if !ok {
+		i = p.builder.addVertex(vertex, p.smoothGroup)
+		p.meshVertices[key] = i
+	}
 
-		parts := strings.Fields(cleanLine)
+	return i
+}
 
-		if parts[0] == "mtllib" {
-			filename = strings.Replace(cleanLine, "mtllib ", "", 1)
-			break
+// splitFields appends the whitespace separated fields of the line to dst, without copying them.
+func splitFields(dst [][]byte, line []byte) [][]byte {
+	start := -1
+	for i, c := range line {
+		isSpace := c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f'
+		if isSpace && start >= 0 {
+			dst = append(dst, line[start:i])
+			start = -1
+		} else if !isSpace && start < 0 {
+			start = i
 		}
 	}
-	mtlTexDict := make(map[string]*Texture)
-	if filename != "" {
-		bytes, err := os.ReadFile(filepath.Join(basePath, filename))
+	if start >= 0 {
+		dst = append(dst, line[start:])
+	}
 
-		if err != nil {
-			zenity.Error(fmt.Sprintf("Error loading the .mtl file '%s'.\n%s", filename, err), zenity.Title("Material load error"), zenity.ErrorIcon)
-			panic(err)
+	return dst
+}
+
+// parseCoordinates reads the 3 coordinates of a 'v' or 'vn' record. On error, it returns the
+// offending token and the error message.
+func parseCoordinates(fields [][]byte) ([3]float64, []byte, string) {
+	coordinates := [3]float64{}
+
+	if len(fields) < 4 {
+		return coordinates, fields[0], fmt.Sprintf("expected 3 coordinates, got %d", len(fields)-1)
+	}
+
+	for i := range coordinates {
+		num, ok := parseFloat32(fields[i+1])
+		if !ok {
+			return coordinates, fields[i+1], "invalid coordinate"
 		}
+		coordinates[i] = num
+	}
 
-		var mtlKey string
+	return coordinates, nil, ""
+}
 
-		for _, line := range strings.Split(string(bytes), "\n") {
-			cleanLine := strings.TrimSpace(line)
-			if cleanLine == "" {
-				continue
-			}
+// Exact powers of ten as float64, for the parseFloat32 fast path
+var float64Pow10 = [...]float64{1e0, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10, 1e11, 1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18, 1e19, 1e20, 1e21, 1e22}
+
+// parseFloat32 reads a decimal number as strconv.ParseFloat(s, 32) does, returning the nearest
+// float32 value, but without allocating. Plain numbers of up to 15 significant digits are computed
+// exactly with a single float64 operation, and the rest are left to strconv.
+func parseFloat32(b []byte) (float64, bool) {
+	i := 0
+	negative := false
+	if i < len(b) && (b[i] == '-' || b[i] == '+') {
+		negative = b[i] == '-'
+		i++
+	}
 
-			if strings.Contains(cleanLine, "newmtl") {
-				mtlKey = strings.Fields(cleanLine)[1]
+	mantissa := uint64(0)
+	digits, exponent := 0, 0
+	sawDigits, sawDot := false, false
+	for ; i < len(b); i++ {
+		c := b[i]
+		if c >= '0' && c <= '9' {
+			sawDigits = true
+			if mantissa == 0 && c == '0' {
+				if sawDot {
+					exponent--
+				}
+				continue
 			}
-
-			if strings.Contains(cleanLine, ".png") ||
-				strings.Contains(cleanLine, ".jpg") ||
-				strings.Contains(cleanLine, ".jpeg") ||
-				strings.Contains(cleanLine, ".jif") {
-				prefix := strings.Fields(cleanLine)[0] + " " // Trim the texture prefix (e.g 'map_Ka')
-				texFileName := strings.Replace(cleanLine, prefix, "", 1)
-				texFilePath := filepath.Join(basePath, texFileName)
-				mtlTexDict[mtlKey] = LoadTexture(texFilePath)
+			mantissa = mantissa*10 + uint64(c-'0')
+			digits++
+			if sawDot {
+				exponent--
 			}
-
+		} else if c == '.' && !sawDot {
+			sawDot = true
+		} else {
+			break
 		}
 	}
 
-	return mtlTexDict
-}
+	if i < len(b) && (b[i] == 'e' || b[i] == 'E') && sawDigits {
+		i++
+		expNegative := false
+		if i < len(b) && (b[i] == '-' || b[i] == '+') {
+			expNegative = b[i] == '-'
+			i++
+		}
 
-func GetTriangles(bytes []byte, mtlTex map[string]*Texture, vertices []Vector4, texVertices []TexVector) []Triangle {
-	tris := []Triangle{}
+		exp, expDigits := 0, 0
+		for ; i < len(b) && b[i] >= '0' && b[i] <= '9' && exp < 10000; i++ {
+			exp = exp*10 + int(b[i]-'0')
+			expDigits++
+		}
+		if expDigits == 0 {
+			return parseFloat32Slow(b)
+		}
 
-	for _, line := range strings.Split(string(bytes), "\n") {
-		cleanLine := strings.TrimSpace(line)
-		if cleanLine == "" {
-			continue
+		if expNegative {
+			exponent -= exp
+		} else {
+			exponent += exp
 		}
+	}
 
-		parts := strings.Fields(cleanLine)
+	if i != len(b) || !sawDigits || digits > 15 || exponent < -22 || exponent > 22 {
+		return parseFloat32Slow(b)
+	}
 
-		if parts[0] == "usemtl" {
-			lastTexture = mtlTex[parts[1]]
-		} else if parts[0] == "f" {
-			triangle := Triangle{}
+	value := float64(mantissa)
+	if exponent < 0 {
+		value /= float64Pow10[-exponent]
+	} else {
+		value *= float64Pow10[exponent]
+	}
 
-			for i := 1; i < 4; i++ {
-				isTextured := true
+	// Rounding the exact float64 to float32 only differs from rounding the decimal number when the
+	// float64 falls right between two float32 values, or outside of the normal float32 range
+	if value != 0 && (math.Float64bits(value)&(1<<29-1) == 1<<28 || value < 1e-37 || value > 1e38) {
+		return parseFloat32Slow(b)
+	}
 
-				vParts := strings.Split(parts[i], "/")
-				vIndexString := strings.Split(parts[i], "/")[0]
+	if negative {
+		value = -value
+	}
 
-				var vTexIndexString string
-				if len(vParts) == 2 {
-					vTexIndexString = strings.Split(parts[i], "/")[1]
-				} else if len(vParts) == 3 {
-					vTexIndexString = strings.Split(parts[i], "/")[1]
-				}
+	return float64(float32(value)), true
+}
 
-				if vTexIndexString == "" {
-					isTextured = false
-				}
+func parseFloat32Slow(b []byte) (float64, bool) {
+	num, err := strconv.ParseFloat(string(b), 32)
+	return num, err == nil
+}
 
-				vIndex, err := strconv.Atoi(vIndexString)
-				if err != nil {
-					zenity.Error(fmt.Sprintf("Error parsing a vertice.\n%s", err), zenity.Title("Mesh parsing error"), zenity.ErrorIcon)
-					panic(err)
-				}
+// parseIndex reads a decimal integer, saturating instead of overflowing.
+func parseIndex(b []byte) (int, bool) {
+	i := 0
+	negative := false
+	if len(b) > 0 && (b[0] == '-' || b[0] == '+') {
+		negative = b[0] == '-'
+		i++
+	}
+	if i == len(b) {
+		return 0, false
+	}
 
-				vTexIndex := 0
-				if isTextured {
-					vTexIndex, err = strconv.Atoi(vTexIndexString)
-					if err != nil {
-						zenity.Error(fmt.Sprintf("Error parsing a vertice.\n%s", err), zenity.Title("Mesh parsing error"), zenity.ErrorIcon)
-						panic(err)
-					}
-				}
+	n := 0
+	for ; i < len(b); i++ {
+		if b[i] < '0' || b[i] > '9' {
+			return 0, false
+		}
+		n = min(n*10+int(b[i]-'0'), math.MaxInt32)
+	}
 
-				triangle.tex = lastTexture
-				triangle.vecs[i-1] = vertices[vIndex-1]
-				if isTextured {
-					triangle.vecs[i-1].texVec = texVertices[vTexIndex-1]
-				}
-			}
+	if negative {
+		return -n, true
+	}
+	return n, true
+}
 
-			tris = append(tris, triangle)
-		}
+// resolveIndex converts an OBJ index into a 0-based one. Positive indices are 1-based, while
+// negative ones are relative to the amount of elements defined so far (-1 being the last one).
+func resolveIndex(token []byte, defined int) (int, error) {
+	index, ok := parseIndex(token)
+	if !ok {
+		return 0, errors.New("invalid")
+	}
+
+	if index < 0 {
+		index += defined
+	} else {
+		index--
+	}
+
+	if index < 0 || index >= defined {
+		return 0, errors.New("out of range")
 	}
 
-	return tris
+	return index, nil
 }
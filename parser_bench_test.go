@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"testing"
+)
+
+// A kind of generated .obj file parsed by the benchmark
+type benchCase struct {
+	name            string
+	texCoords       bool
+	normals         bool
+	quads           bool // Quad faces instead of triangles, to be triangulated
+	negativeIndices bool
+}
+
+var benchCases = []benchCase{
+	{name: "positions"},
+	{name: "uv+normals", texCoords: true, normals: true},
+	{name: "quads", texCoords: true, normals: true, quads: true},
+	{name: "relative", normals: true, negativeIndices: true},
+}
+
+var benchTriangles = []int{10000, 100000, 1000000}
+
+// BenchmarkParseObj measures the .obj parser over generated files of increasing size, as in
+// 'go test -bench ParseObj -run ^$'. The MB/s are of the file read.
+func BenchmarkParseObj(b *testing.B) {
+	for _, bc := range benchCases {
+		b.Run(bc.name, func(b *testing.B) {
+			for _, triangles := range benchTriangles {
+				b.Run(strconv.Itoa(triangles), func(b *testing.B) {
+					var buffer bytes.Buffer
+					writeBenchObj(&buffer, bc, triangles)
+					data := buffer.Bytes()
+
+					b.SetBytes(int64(len(data)))
+					b.ReportAllocs()
+					b.ResetTimer()
+
+					for i := 0; i < b.N; i++ {
+						if _, err := DecodeMesh(context.Background(), bytes.NewReader(data), "bench.obj"); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+// writeBenchObj writes a wavy grid with about the given amount of triangles, similar to a
+// scanned surface.
+func writeBenchObj(w io.Writer, bc benchCase, triangles int) {
+	side := 1
+	for 2*side*side < triangles {
+		side++
+	}
+
+	buffer := make([]byte, 0, 128)
+	write := func(format string, args ...interface{}) {
+		buffer = fmt.Appendf(buffer[:0], format, args...)
+		w.Write(buffer)
+	}
+
+	for y := 0; y <= side; y++ {
+		for x := 0; x <= side; x++ {
+			fx, fy := float64(x)/float64(side), float64(y)/float64(side)
+			write("v %.6f %.6f %.6f\n", fx*10-5, fy*10-5, 0.25*float64((x*7+y*13)%17)/17)
+			if bc.texCoords {
+				write("vt %.6f %.6f\n", fx, fy)
+			}
+			if bc.normals {
+				write("vn %.6f %.6f %.6f\n", 0.0, 0.0, 1.0)
+			}
+		}
+	}
+
+	index := func(x, y int) int {
+		return y*(side+1) + x + 1
+	}
+	vertex := func(i, defined int) string {
+		if bc.negativeIndices {
+			i -= defined + 1
+		}
+
+		switch {
+		case bc.texCoords && bc.normals:
+			return fmt.Sprintf("%d/%d/%d", i, i, i)
+		case bc.normals:
+			return fmt.Sprintf("%d//%d", i, i)
+		}
+		return strconv.Itoa(i)
+	}
+
+	defined := (side + 1) * (side + 1)
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			a, b, c, d := index(x, y), index(x+1, y), index(x+1, y+1), index(x, y+1)
+			if bc.quads {
+				write("f %s %s %s %s\n", vertex(a, defined), vertex(b, defined), vertex(c, defined), vertex(d, defined))
+			} else {
+				write("f %s %s %s\n", vertex(a, defined), vertex(b, defined), vertex(c, defined))
+				write("f %s %s %s\n", vertex(a, defined), vertex(c, defined), vertex(d, defined))
+			}
+		}
+	}
+}
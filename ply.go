@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterMeshFormat("PLY", []string{".ply"}, "ply", ParsePly)
+	RegisterMeshEncoder("PLY", []string{".ply"}, WritePly)
+}
+
+type plyFormat int
+
+const (
+	PLY_ASCII plyFormat = iota
+	PLY_BINARY_LITTLE_ENDIAN
+	PLY_BINARY_BIG_ENDIAN
+)
+
+var plyFormatNames = map[string]plyFormat{
+	"ascii":                PLY_ASCII,
+	"binary_little_endian": PLY_BINARY_LITTLE_ENDIAN,
+	"binary_big_endian":    PLY_BINARY_BIG_ENDIAN,
+}
+
+// The type of a PLY property value
+type plyType struct {
+	size   int
+	float  bool
+	signed bool
+}
+
+var plyTypes = map[string]plyType{
+	"char": {1, false, true}, "int8": {1, false, true},
+	"uchar": {1, false, false}, "uint8": {1, false, false},
+	"short": {2, false, true}, "int16": {2, false, true},
+	"ushort": {2, false, false}, "uint16": {2, false, false},
+	"int": {4, false, true}, "int32": {4, false, true},
+	"uint": {4, false, false}, "uint32": {4, false, false},
+	"float": {4, true, true}, "float32": {4, true, true},
+	"double": {8, true, true}, "float64": {8, true, true},
+}
+
+// maxValue returns the largest value of an integer type, which colors of that type are scaled by.
+func (t plyType) maxValue() float64 {
+	bits := t.size * 8
+	if t.signed {
+		bits--
+	}
+
+	return math.Pow(2, float64(bits)) - 1
+}
+
+type plyProperty struct {
+	name      string
+	valueType plyType
+	list      bool
+	countType plyType // Type of the length of lists
+}
+
+type plyElement struct {
+	name       string
+	count      int
+	properties []plyProperty
+}
+
+// plyParser reads a .ply file, its header line by line and then its elements value by value.
+type plyParser struct {
+	ctx      context.Context
+	filename string
+	r        *bufio.Reader
+
+	format   plyFormat
+	elements []plyElement
+	line     int // Of the ASCII values, for the error messages
+
+	token  []byte // Reused between ASCII values
+	buffer [8]byte
+
+	builder     meshBuilder
+	vertexCount int
+	hasVertices bool
+	hasColors   bool
+	faceAmount  int
+	polygon     []Vector4
+	indices     []int32
+}
+
+// ParsePly reads an ASCII or binary (little or big endian) .ply model into a mesh, with its
+// vertex normals, texture coordinates and colors. Files without faces are loaded as point clouds.
+func ParsePly(ctx context.Context, r io.Reader, filename string) (*Mesh, error) {
+	p := plyParser{ctx: ctx, filename: filename, r: bufio.NewReaderSize(r, 64*1024)}
+
+	if err := p.readHeader(); err != nil {
+		return nil, err
+	}
+
+	for _, element := range p.elements {
+		var err error
+		switch element.name {
+		case "vertex":
+			err = p.readVertices(element)
+		case "face":
+			if !p.hasVertices && element.count > 0 {
+				return nil, &ParseError{File: filename, Msg: "faces before the vertices are not supported"}
+			}
+			err = p.readFaces(element)
+		default:
+			err = p.skipElement(element)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	startPhase(ctx, LOAD_SMOOTHING, 0)
+	mesh := p.builder.build()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mesh.faceAmount = p.faceAmount
+	mesh.triangleAmount = p.builder.triangles
+	mesh.vertexAmount = p.vertexCount
+
+	return mesh, nil
+}
+
+func (p *plyParser) readHeader() error {
+	for lineIdx := 0; ; lineIdx++ {
+		line, err := p.r.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return &ParseError{File: p.filename, Msg: "missing end_header"}
+			}
+			return err
+		}
+		p.line++
+
+		parts := strings.Fields(line)
+		if lineIdx == 0 {
+			if len(parts) != 1 || parts[0] != "ply" {
+				return newParseError(p.filename, lineIdx, line, strings.TrimSpace(line), "not a PLY file")
+			}
+			continue
+		}
+		if len(parts) == 0 {
+			continue
+		}
+
+		switch parts[0] {
+		case "format":
+			format, ok := plyFormatNames[strings.Join(parts[1:2], "")]
+			if !ok {
+				return newParseError(p.filename, lineIdx, line, strings.Join(parts[1:], " "), "unsupported format")
+			}
+			p.format = format
+		case "element":
+			count := 0
+			if len(parts) == 3 {
+				count, err = strconv.Atoi(parts[2])
+			}
+			if len(parts) != 3 || err != nil || count < 0 {
+				return newParseError(p.filename, lineIdx, line, parts[0], "expected an element name and count")
+			}
+			p.elements = append(p.elements, plyElement{name: parts[1], count: count})
+		case "property":
+			if len(p.elements) == 0 {
+				return newParseError(p.filename, lineIdx, line, parts[0], "property outside of an element")
+			}
+
+			property := plyProperty{}
+			ok := false
+			if len(parts) == 5 && parts[1] == "list" {
+				property.list = true
+				property.countType, ok = plyTypes[parts[2]]
+				if ok {
+					property.valueType, ok = plyTypes[parts[3]]
+				}
+			} else if len(parts) == 3 {
+				property.valueType, ok = plyTypes[parts[1]]
+			}
+			if !ok {
+				return newParseError(p.filename, lineIdx, line, parts[0], "expected a property type and name")
+			}
+
+			property.name = parts[len(parts)-1]
+			element := &p.elements[len(p.elements)-1]
+			element.properties = append(element.properties, property)
+		case "end_header":
+			return nil
+		}
+	}
+}
+
+// readValue reads the next value of the given type.
+func (p *plyParser) readValue(t plyType) (float64, error) {
+	if p.format == PLY_ASCII {
+		return p.readAsciiValue(t)
+	}
+
+	b := p.buffer[:t.size]
+	if _, err := io.ReadFull(p.r, b); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, &ParseError{File: p.filename, Msg: "unexpected end of file"}
+		}
+		return 0, err
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if p.format == PLY_BINARY_BIG_ENDIAN {
+		order = binary.BigEndian
+	}
+
+	switch {
+	case t.size == 1 && t.signed:
+		return float64(int8(b[0])), nil
+	case t.size == 1:
+		return float64(b[0]), nil
+	case t.size == 2 && t.signed:
+		return float64(int16(order.Uint16(b))), nil
+	case t.size == 2:
+		return float64(order.Uint16(b)), nil
+	case t.size == 4 && t.float:
+		return float64(math.Float32frombits(order.Uint32(b))), nil
+	case t.size == 4 && t.signed:
+		return float64(int32(order.Uint32(b))), nil
+	case t.size == 4:
+		return float64(order.Uint32(b)), nil
+	default:
+		return math.Float64frombits(order.Uint64(b)), nil
+	}
+}
+
+func (p *plyParser) readAsciiValue(t plyType) (float64, error) {
+	p.token = p.token[:0]
+	for {
+		c, err := p.r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(p.token) > 0 {
+				break
+			}
+			if errors.Is(err, io.EOF) {
+				return 0, &ParseError{File: p.filename, Line: p.line + 1, Msg: "unexpected end of file"}
+			}
+			return 0, err
+		}
+
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			if c == '\n' {
+				p.line++
+			}
+			if len(p.token) > 0 {
+				if c == '\n' {
+					p.r.UnreadByte()
+					p.line--
+				}
+				break
+			}
+			continue
+		}
+		p.token = append(p.token, c)
+	}
+
+	var value float64
+	ok := false
+	switch {
+	case t.float && t.size == 4:
+		value, ok = parseFloat32(p.token)
+	case t.float:
+		var err error
+		value, err = strconv.ParseFloat(string(p.token), 64)
+		ok = err == nil
+	default:
+		var n int
+		n, ok = parseIndex(p.token)
+		value = float64(n)
+	}
+	if !ok {
+		return 0, &ParseError{File: p.filename, Line: p.line + 1, Token: string(p.token), Msg: "invalid value"}
+	}
+
+	return value, nil
+}
+
+// readProperty reads a scalar property into values, or every item of a list property.
+func (p *plyParser) readProperty(property plyProperty, values []float64) ([]float64, error) {
+	if !property.list {
+		value, err := p.readValue(property.valueType)
+		return append(values, value), err
+	}
+
+	count, err := p.readValue(property.countType)
+	if err != nil {
+		return values, err
+	}
+	if count < 0 {
+		return values, &ParseError{File: p.filename, Line: p.asciiLine(), Msg: "negative list length"}
+	}
+
+	for i := 0; i < int(count); i++ {
+		value, err := p.readValue(property.valueType)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// asciiLine returns the line of the last ASCII value, or 0 for binary files.
+func (p *plyParser) asciiLine() int {
+	if p.format == PLY_ASCII {
+		return p.line + 1
+	}
+
+	return 0
+}
+
+func (p *plyParser) readVertices(element plyElement) error {
+	// Index of each known property among the values of a vertex, -1 when missing
+	find := func(names ...string) int {
+		for i, property := range element.properties {
+			for _, name := range names {
+				if property.name == name && !property.list {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+
+	position := [3]int{find("x"), find("y"), find("z")}
+	normal := [3]int{find("nx"), find("ny"), find("nz")}
+	texCoord := [2]int{find("u", "s", "texture_u", "texture_s"), find("v", "t", "texture_v", "texture_t")}
+	color := [3]int{find("red", "diffuse_red", "r"), find("green", "diffuse_green", "g"), find("blue", "diffuse_blue", "b")}
+
+	if position[0] < 0 || position[1] < 0 || position[2] < 0 {
+		return &ParseError{File: p.filename, Msg: "vertices without x, y and z properties"}
+	}
+	hasNormals := normal[0] >= 0 && normal[1] >= 0 && normal[2] >= 0
+	hasTexCoords := texCoord[0] >= 0 && texCoord[1] >= 0
+	p.hasColors = color[0] >= 0 && color[1] >= 0 && color[2] >= 0
+
+	// Integer colors go from 0 to the largest value of their type, float ones from 0 to 1
+	colorScale := [3]float64{1, 1, 1}
+	if p.hasColors {
+		for i, c := range color {
+			if t := element.properties[c].valueType; !t.float {
+				colorScale[i] = 1 / t.maxValue()
+			}
+		}
+	}
+
+	startPhase(p.ctx, LOAD_VERTICES, element.count)
+
+	values := make([]float64, 0, len(element.properties))
+	for i := 0; i < element.count; i++ {
+		if i%PROGRESS_INTERVAL == 0 {
+			if err := reportProgress(p.ctx, i); err != nil {
+				return err
+			}
+		}
+
+		values = values[:0]
+		for _, property := range element.properties {
+			var err error
+			if property.list {
+				// Lists of a vertex are not used, but keep the values of the next properties aligned
+				length := len(values)
+				values, err = p.readProperty(property, values)
+				values = append(values[:length], 0)
+			} else {
+				values, err = p.readProperty(property, values)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		vertex := NewVector4(values[position[0]], values[position[1]], values[position[2]], 1)
+		if hasNormals {
+			vertex.normVec = NewNormalVector(values[normal[0]], values[normal[1]], values[normal[2]])
+		}
+		if hasTexCoords {
+			vertex.texVec = NewTexVector(values[texCoord[0]], values[texCoord[1]], 0)
+		}
+		if p.hasColors {
+			vertex.color = NewColorVector(values[color[0]]*colorScale[0], values[color[1]]*colorScale[1], values[color[2]]*colorScale[2])
+		}
+
+		// Without normals, the faces sharing a vertex are smoothed together
+		p.builder.addVertex(vertex, 1)
+	}
+
+	p.vertexCount += element.count
+	p.hasVertices = true
+
+	return nil
+}
+
+func (p *plyParser) readFaces(element plyElement) error {
+	indicesProperty := -1
+	for i, property := range element.properties {
+		if property.list && (property.name == "vertex_indices" || property.name == "vertex_index") {
+			indicesProperty = i
+		}
+	}
+	if indicesProperty < 0 {
+		return &ParseError{File: p.filename, Msg: "faces without a vertex_indices property"}
+	}
+
+	startPhase(p.ctx, LOAD_FACES, element.count)
+
+	values := []float64{}
+	for i := 0; i < element.count; i++ {
+		if i%PROGRESS_INTERVAL == 0 {
+			if err := reportProgress(p.ctx, i); err != nil {
+				return err
+			}
+		}
+
+		for j, property := range element.properties {
+			values = values[:0]
+			var err error
+			values, err = p.readProperty(property, values)
+			if err != nil {
+				return err
+			}
+			if j == indicesProperty {
+				if err := p.addFace(values, i); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// addFace adds the polygon with the given vertex indices, triangulating it.
+func (p *plyParser) addFace(values []float64, faceIdx int) error {
+	if len(values) < 3 {
+		return &ParseError{File: p.filename, Line: p.asciiLine(), Msg: fmt.Sprintf("face %d has less than 3 vertices", faceIdx)}
+	}
+
+	p.polygon = p.polygon[:0]
+	p.indices = p.indices[:0]
+	for _, value := range values {
+		index := int(value)
+		if index < 0 || index >= p.vertexCount {
+			return &ParseError{File: p.filename, Line: p.asciiLine(), Token: strconv.Itoa(index), Msg: fmt.Sprintf("vertex index of face %d out of range", faceIdx)}
+		}
+
+		p.indices = append(p.indices, int32(index))
+		position := p.builder.mesh.positions[index]
+		p.polygon = append(p.polygon, NewVector4(position[0], position[1], position[2], 1))
+	}
+
+	for _, idx := range TriangulatePolygon(p.polygon) {
+		p.builder.addTriangle(defaultMaterial, p.hasColors, p.indices[idx[0]], p.indices[idx[1]], p.indices[idx[2]])
+	}
+	p.faceAmount++
+
+	return nil
+}
+
+// skipElement reads past the values of an element the mesh doesn't use.
+func (p *plyParser) skipElement(element plyElement) error {
+	values := []float64{}
+	for i := 0; i < element.count; i++ {
+		if i%PROGRESS_INTERVAL == 0 {
+			if err := p.ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		for _, property := range element.properties {
+			var err error
+			values, err = p.readProperty(property, values[:0])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// WritePly writes the mesh as a binary little endian .ply model, or an ASCII one with opts.ASCII,
+// with its normals, texture coordinates and vertex colors. Materials are not kept.
+func WritePly(w io.Writer, mesh *Mesh, filename string, opts EncodeOptions) error {
+	hasTexCoords := mesh.hasTexCoords()
+	hasColors := len(mesh.colors) > 0
+
+	format := "binary_little_endian"
+	if opts.ASCII {
+		format = "ascii"
+	}
+
+	header := fmt.Sprintf("ply\nformat %s 1.0\ncomment Exported by 3d-viewer\nelement vertex %d\n", format, len(mesh.positions))
+	header += "property float x\nproperty float y\nproperty float z\nproperty float nx\nproperty float ny\nproperty float nz\n"
+	if hasTexCoords {
+		header += "property float s\nproperty float t\n"
+	}
+	if hasColors {
+		header += "property uchar red\nproperty uchar green\nproperty uchar blue\n"
+	}
+	header += fmt.Sprintf("element face %d\nproperty list uchar int vertex_indices\nend_header\n", mesh.triangleAmount)
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+
+	buffer := []byte{}
+	for i, p := range mesh.positions {
+		n := mesh.normals[i]
+		values := []float64{p[0], p[1], p[2], n.x, n.y, n.z}
+		if hasTexCoords {
+			values = append(values, mesh.texCoords[i].u, mesh.texCoords[i].v)
+		}
+
+		var color [3]uint8
+		if hasColors {
+			c := mesh.colors[i]
+			color = [3]uint8{colorByte(c.r), colorByte(c.g), colorByte(c.b)}
+		}
+
+		buffer = buffer[:0]
+		if opts.ASCII {
+			buffer = appendObjFloats(buffer, values...)[1:]
+			if hasColors {
+				for _, component := range color {
+					buffer = strconv.AppendUint(append(buffer, ' '), uint64(component), 10)
+				}
+			}
+			buffer = append(buffer, '\n')
+		} else {
+			for _, value := range values {
+				buffer = binary.LittleEndian.AppendUint32(buffer, math.Float32bits(float32(value)))
+			}
+			if hasColors {
+				buffer = append(buffer, color[:]...)
+			}
+		}
+
+		if _, err := w.Write(buffer); err != nil {
+			return err
+		}
+	}
+
+	for _, submesh := range mesh.submeshes {
+		for i := 0; i+2 < len(submesh.indices); i += 3 {
+			buffer = buffer[:0]
+			if opts.ASCII {
+				buffer = append(buffer, '3')
+				for _, index := range submesh.indices[i : i+3] {
+					buffer = strconv.AppendInt(append(buffer, ' '), int64(index), 10)
+				}
+				buffer = append(buffer, '\n')
+			} else {
+				buffer = append(buffer, 3)
+				for _, index := range submesh.indices[i : i+3] {
+					buffer = binary.LittleEndian.AppendUint32(buffer, uint32(index))
+				}
+			}
+
+			if _, err := w.Write(buffer); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func colorByte(value float64) uint8 {
+	return uint8(math.Round(math.Max(0, math.Min(1, value)) * 255))
+}
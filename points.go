@@ -0,0 +1,127 @@
+package main
+
+import (
+	"image/color"
+	"math"
+)
+
+// A vertex of a point cloud in screen space, already shaded
+type projectedPoint struct {
+	x, y    int     // Top left pixel of its square
+	depth   float64 // View space z, as in the depth buffer
+	color   color.RGBA
+	visible bool // In front of the near plane and on screen
+}
+
+// drawPoints draws the vertices of the point cloud, already in the vertex cache, as squares of
+// pointSize pixels. They are lit when they have a normal, and keep their color otherwise.
+func (r *Renderer) drawPoints(mesh *Mesh) {
+	if cap(r.points) < len(r.viewVertices) {
+		r.points = make([]projectedPoint, len(r.viewVertices))
+	}
+	r.points = r.points[:len(r.viewVertices)]
+
+	size := r.pointPixels()
+	nBatches := (len(r.points) + PROJECT_BATCH_SIZE - 1) / PROJECT_BATCH_SIZE
+	r.parallel(nBatches, func(i int) {
+		end := min((i+1)*PROJECT_BATCH_SIZE, len(r.points))
+		for n := i * PROJECT_BATCH_SIZE; n < end; n++ {
+			r.points[n] = r.projectPoint(mesh, n, size)
+		}
+	})
+
+	for i := range r.tiles {
+		r.tiles[i].tris = r.tiles[i].tris[:0]
+		r.tiles[i].points = r.tiles[i].points[:0]
+	}
+
+	tilesX := (r.width + TILE_SIZE - 1) / TILE_SIZE
+	for n := range r.points {
+		p := &r.points[n]
+		if !p.visible {
+			continue
+		}
+
+		tx0, ty0 := max(p.x, 0)/TILE_SIZE, max(p.y, 0)/TILE_SIZE
+		tx1, ty1 := min(p.x+size-1, r.width-1)/TILE_SIZE, min(p.y+size-1, r.height-1)/TILE_SIZE
+		for ty := ty0; ty <= ty1; ty++ {
+			for tx := tx0; tx <= tx1; tx++ {
+				r.tiles[ty*tilesX+tx].points = append(r.tiles[ty*tilesX+tx].points, int32(n))
+			}
+		}
+	}
+
+	r.parallel(len(r.tiles), func(i int) {
+		r.rasterizeTile(&r.tiles[i])
+	})
+}
+
+// pointPixels returns the width of the point squares, a whole number of pixels.
+func (r *Renderer) pointPixels() int {
+	return max(int(math.Round(r.pointSize)), 1)
+}
+
+func (r *Renderer) projectPoint(mesh *Mesh, n int, size int) projectedPoint {
+	v := r.viewVertices[n]
+	if v.z < NEAR_DISTANCE {
+		return projectedPoint{}
+	}
+
+	projected := r.projection.multiplyVector(v)
+	projected = projected.Div(projected.w)
+
+	// Center the square on the point
+	x := int(math.Floor((projected.x+1)*r.widthHalf - float64(size)/2 + 0.5))
+	y := int(math.Floor((projected.y+1)*r.heightHalf - float64(size)/2 + 0.5))
+	if x+size <= 0 || y+size <= 0 || x >= r.width || y >= r.height {
+		return projectedPoint{}
+	}
+
+	m := defaultMaterial
+	base := m.diffuse
+	if len(mesh.colors) > 0 {
+		base = mesh.colors[n]
+	}
+
+	final := base
+	if !v.normVec.IsZero() {
+		normal := v.normVec
+		if r.flipNormals {
+			normal = normal.Scale(-1)
+		}
+
+		diffuse, specular := r.lightAt(v, normal, m)
+		final = base.Mul(m.ambient.Mul(r.ambient)).Add(base.Mul(diffuse)).Add(m.specular.Mul(specular))
+	}
+
+	return projectedPoint{
+		x:       x,
+		y:       y,
+		depth:   v.originalZ,
+		color:   color.RGBA{clampColorComponent(final.r), clampColorComponent(final.g), clampColorComponent(final.b), 255},
+		visible: true,
+	}
+}
+
+// fillPoint draws the part of the point square inside the tile, testing each pixel against the
+// tile depth.
+func (r *Renderer) fillPoint(p *projectedPoint, t *tile) {
+	size := r.pointPixels()
+	w := t.x1 - t.x0
+
+	for y := max(p.y, t.y0); y < min(p.y+size, t.y1); y++ {
+		for x := max(p.x, t.x0); x < min(p.x+size, t.x1); x++ {
+			zIdx := (y-t.y0)*w + x - t.x0
+			if p.depth >= t.depth[zIdx] {
+				continue
+			}
+			t.depth[zIdx] = p.depth
+
+			idx := 4 * (y*r.width + x)
+			r.image.Pix[idx+0] = p.color.R
+			r.image.Pix[idx+1] = p.color.G
+			r.image.Pix[idx+2] = p.color.B
+			r.image.Pix[idx+3] = p.color.A
+		}
+	}
+}
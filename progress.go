@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+)
+
+const PROGRESS_INTERVAL int = 4096 // Lines (or elements) decoded between progress reports and cancellation checks
+
+type LoadPhase int32
+
+const (
+	LOAD_READING LoadPhase = iota
+	LOAD_TEXTURES
+	LOAD_UVS
+	LOAD_NORMALS
+	LOAD_VERTICES
+	LOAD_FACES
+	LOAD_SMOOTHING
+	LOAD_ANALYZING
+)
+
+var loadPhaseNames = []string{"Reading file", "Loading textures", "Reading UVs", "Reading normals", "Reading vertices", "Reading faces", "Generating normals", "Analyzing mesh"}
+
+func (p LoadPhase) String() string {
+	return loadPhaseNames[p]
+}
+
+// LoadProgress is updated by the decoders while a mesh loads in the background, and read by the UI.
+type LoadProgress struct {
+	phase       atomic.Int32
+	done, total atomic.Int64 // Bytes (or elements) of the current phase
+}
+
+func (p *LoadProgress) Phase() LoadPhase {
+	return LoadPhase(p.phase.Load())
+}
+
+// Fraction returns how much of the current phase is done, or -1 when its size is unknown.
+func (p *LoadProgress) Fraction() float64 {
+	total := p.total.Load()
+	if total <= 0 {
+		return -1
+	}
+
+	return min(float64(p.done.Load())/float64(total), 1)
+}
+
+type loadProgressKey struct{}
+
+// WithLoadProgress returns a context for LoadMesh and DecodeMesh that reports their progress to p.
+func WithLoadProgress(ctx context.Context, p *LoadProgress) context.Context {
+	return context.WithValue(ctx, loadProgressKey{}, p)
+}
+
+// startPhase reports the start of a decoding phase of total bytes (or elements, 0 if unknown).
+func startPhase(ctx context.Context, phase LoadPhase, total int) {
+	if p, ok := ctx.Value(loadProgressKey{}).(*LoadProgress); ok {
+		p.total.Store(0)
+		p.done.Store(0)
+		p.phase.Store(int32(phase))
+		p.total.Store(int64(total))
+	}
+}
+
+// setPhase reports a new decoding phase while keeping the progress, for decoders that read every
+// phase in a single pass over the file.
+func setPhase(ctx context.Context, phase LoadPhase) {
+	if p, ok := ctx.Value(loadProgressKey{}).(*LoadProgress); ok {
+		p.phase.Store(int32(phase))
+	}
+}
+
+// reportProgress reports the bytes (or elements) done in the current phase, and returns the
+// context error once the load is cancelled.
+func reportProgress(ctx context.Context, done int) error {
+	if p, ok := ctx.Value(loadProgressKey{}).(*LoadProgress); ok {
+		p.done.Store(int64(done))
+	}
+
+	return ctx.Err()
+}
+
+// progressReader reports the bytes read from a model file, and stops reading once the load is
+// cancelled.
+type progressReader struct {
+	ctx  context.Context
+	r    io.Reader
+	read int
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	if err := pr.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := pr.r.Read(b)
+	pr.read += n
+	reportProgress(pr.ctx, pr.read)
+
+	return n, err
+}
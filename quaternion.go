@@ -0,0 +1,97 @@
+package main
+
+import "math"
+
+// Quaternion represents a rotation, which unlike Euler angles can be composed without gimbal lock.
+type Quaternion struct {
+	x, y, z, w float64
+}
+
+func identityQuaternion() Quaternion {
+	return Quaternion{0, 0, 0, 1}
+}
+
+// NewQuaternion creates the rotation of angle radians around the axis.
+func NewQuaternion(axis Vector4, angle float64) Quaternion {
+	l := axis.Len()
+	if l == 0 {
+		return identityQuaternion()
+	}
+
+	s := math.Sin(angle/2) / l
+	return Quaternion{axis.x * s, axis.y * s, axis.z * s, math.Cos(angle / 2)}
+}
+
+// Mul returns the rotation of q2 followed by the rotation of q1.
+func (q1 Quaternion) Mul(q2 Quaternion) Quaternion {
+	return Quaternion{
+		q1.w*q2.x + q1.x*q2.w + q1.y*q2.z - q1.z*q2.y,
+		q1.w*q2.y - q1.x*q2.z + q1.y*q2.w + q1.z*q2.x,
+		q1.w*q2.z + q1.x*q2.y - q1.y*q2.x + q1.z*q2.w,
+		q1.w*q2.w - q1.x*q2.x - q1.y*q2.y - q1.z*q2.z,
+	}
+}
+
+// Conjugate returns the inverse rotation of the (unit) quaternion.
+func (q Quaternion) Conjugate() Quaternion {
+	return Quaternion{-q.x, -q.y, -q.z, q.w}
+}
+
+func (q Quaternion) Normalise() Quaternion {
+	l := math.Sqrt(q.x*q.x + q.y*q.y + q.z*q.z + q.w*q.w)
+	if l == 0 {
+		return identityQuaternion()
+	}
+
+	return Quaternion{q.x / l, q.y / l, q.z / l, q.w / l}
+}
+
+// matrix returns the rotation matrix of the (unit) quaternion.
+func (q Quaternion) matrix() mat44 {
+	x, y, z, w := q.x, q.y, q.z, q.w
+
+	return mat44{
+		m: [4][4]float64{
+			{1 - 2*(y*y+z*z), 2 * (x*y + z*w), 2 * (x*z - y*w), 0},
+			{2 * (x*y - z*w), 1 - 2*(x*x+z*z), 2 * (y*z + x*w), 0},
+			{2 * (x*z + y*w), 2 * (y*z - x*w), 1 - 2*(x*x+y*y), 0},
+			{0, 0, 0, 1},
+		},
+	}
+}
+
+// quaternionFromMatrix returns the rotation of the 3x3 part of the matrix, which must be a pure rotation.
+func quaternionFromMatrix(mat mat44) Quaternion {
+	m := mat.m
+	trace := m[0][0] + m[1][1] + m[2][2]
+
+	// Divide by the largest component, for precision
+	var q Quaternion
+	switch {
+	case trace > 0:
+		s := math.Sqrt(trace+1) * 2 // 4w
+		q = Quaternion{(m[1][2] - m[2][1]) / s, (m[2][0] - m[0][2]) / s, (m[0][1] - m[1][0]) / s, s / 4}
+	case m[0][0] > m[1][1] && m[0][0] > m[2][2]:
+		s := math.Sqrt(1+m[0][0]-m[1][1]-m[2][2]) * 2 // 4x
+		q = Quaternion{s / 4, (m[0][1] + m[1][0]) / s, (m[0][2] + m[2][0]) / s, (m[1][2] - m[2][1]) / s}
+	case m[1][1] > m[2][2]:
+		s := math.Sqrt(1+m[1][1]-m[0][0]-m[2][2]) * 2 // 4y
+		q = Quaternion{(m[0][1] + m[1][0]) / s, s / 4, (m[1][2] + m[2][1]) / s, (m[2][0] - m[0][2]) / s}
+	default:
+		s := math.Sqrt(1+m[2][2]-m[0][0]-m[1][1]) * 2 // 4z
+		q = Quaternion{(m[0][2] + m[2][0]) / s, (m[1][2] + m[2][1]) / s, s / 4, (m[0][1] - m[1][0]) / s}
+	}
+
+	return q.Normalise()
+}
+
+// Rotate applies the rotation to the x, y and z components of the vector.
+func (q Quaternion) Rotate(v Vector4) Vector4 {
+	m := q.matrix()
+	return NewVector4(
+		v.x*m.m[0][0]+v.y*m.m[1][0]+v.z*m.m[2][0],
+		v.x*m.m[0][1]+v.y*m.m[1][1]+v.z*m.m[2][1],
+		v.x*m.m[0][2]+v.y*m.m[1][2]+v.z*m.m[2][2],
+		v.w,
+	)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const MAX_RECENT_FILES int = 8
+
+// recentFilesPath returns the file where the recently opened models are saved between sessions,
+// in the user configuration directory.
+func recentFilesPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "3d-viewer", "recent.txt"), nil
+}
+
+// LoadRecentFiles returns the recently opened models, the most recent first. A missing or
+// unreadable list is treated as empty.
+func LoadRecentFiles() []string {
+	path, err := recentFilesPath()
+	if err != nil {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	files := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() && len(files) < MAX_RECENT_FILES {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			files = append(files, line)
+		}
+	}
+
+	return files
+}
+
+func SaveRecentFiles(files []string) error {
+	path, err := recentFilesPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(strings.Join(files, "\n")+"\n"), 0o644)
+}
+
+// addRecentFile moves the file to the top of the list, adding it if needed and dropping the
+// oldest ones past MAX_RECENT_FILES.
+func addRecentFile(files []string, filename string) []string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+
+	updated := []string{filename}
+	for _, f := range files {
+		if f != filename && len(updated) < MAX_RECENT_FILES {
+			updated = append(updated, f)
+		}
+	}
+
+	return updated
+}
+
+func removeRecentFile(files []string, filename string) []string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+
+	updated := []string{}
+	for _, f := range files {
+		if f != filename {
+			updated = append(updated, f)
+		}
+	}
+
+	return updated
+}
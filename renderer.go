@@ -0,0 +1,338 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"runtime"
+)
+
+// Renderer rasterizes meshes into an in-memory RGBA image with its own depth
+// buffer. It has no dependency on SDL, so it can be used both by the viewer
+// window and by the headless commands.
+type Renderer struct {
+	width, height           int
+	widthFloat, heightFloat float64
+	widthHalf, heightHalf   float64
+
+	image       *image.RGBA
+	depthBuffer []float64
+
+	projection mat44
+	camera     Vector4
+
+	lights     []Light
+	ambient    ColorVector // Scene ambient light, so faces in the shadow are still visible
+	viewLights []viewLight // The lights in view space, for the current frame
+
+	shadows    bool
+	shadowBias float64     // Depth bias of the shadow map tests, in shadow map texels
+	shadowMaps []shadowMap // Reused between frames
+	depthOnly  bool        // Only fills the depth buffer, with exact depths, as shadow map renderers do
+
+	ssao          bool
+	ssaoRadius    float64 // In model radii
+	ssaoSamples   int
+	ssaoBlur      int       // Pixels
+	ssaoKernel    []Vector4 // Reused between frames, like the occlusion buffers
+	occlusion     []float64
+	occlusionBlur []float64
+
+	flipNormals bool
+	shading     ShadingMode
+	filter      TextureFilter
+
+	mode      RenderMode
+	lineColor color.RGBA
+	lineWidth float64      // In pixels
+	edges     [][2]Vector4 // Screen space edges to draw after the faces
+
+	pointSize float64          // In pixels, of the vertices of point clouds
+	points    []projectedPoint // Reused between frames to avoid allocations
+
+	workers      int // Goroutines projecting and rasterizing the triangles
+	tiles        []tile
+	batches      []projectedBatch // Reused between frames to avoid allocations
+	viewVertices []Vector4        // Post-transform vertex cache: the mesh vertices in view space
+}
+
+func NewRenderer(width, height int) *Renderer {
+	r := Renderer{
+		camera: NewVector4(0, 0, 0, 1),
+
+		lights:  DefaultLights(),
+		ambient: NewColorVector(AMBIENT_LIGHT, AMBIENT_LIGHT, AMBIENT_LIGHT),
+
+		shadowBias: DEFAULT_SHADOW_BIAS,
+
+		ssaoRadius:  DEFAULT_SSAO_RADIUS,
+		ssaoSamples: DEFAULT_SSAO_SAMPLES,
+		ssaoBlur:    DEFAULT_SSAO_BLUR,
+
+		shading: SHADING_PHONG,
+		filter:  FILTER_TRILINEAR,
+
+		mode:      RENDER_SHADED,
+		lineColor: DEFAULT_LINE_COLOR,
+		lineWidth: 1,
+		pointSize: 1,
+
+		workers: runtime.NumCPU(),
+	}
+
+	r.Resize(width, height)
+
+	return &r
+}
+
+// Resize re-creates the image, depth buffer and tiles for the new resolution, and updates the
+// projection to its aspect ratio. The image is cleared.
+func (r *Renderer) Resize(width, height int) {
+	r.width, r.height = width, height
+
+	r.widthFloat, r.heightFloat = float64(width), float64(height)
+	r.widthHalf, r.heightHalf = float64(width)*0.5, float64(height)*0.5
+
+	r.image = image.NewRGBA(image.Rect(0, 0, width, height))
+	r.depthBuffer = make([]float64, width*height)
+	r.tiles = newTiles(width, height)
+
+	r.projection = projectionMatrix(float64(height)/float64(width), FOV_DEGREES, NEAR_DISTANCE, FAR_DISTANCE)
+
+	r.Clear()
+}
+
+func (r *Renderer) Image() *image.RGBA {
+	return r.image
+}
+
+// Clear fills the image with the background color and resets the depth buffer.
+func (r *Renderer) Clear() {
+	bg := color.RGBA{
+		R: uint8(BG_COLOR & 0xff),
+		G: uint8((BG_COLOR >> 8) & 0xff),
+		B: uint8((BG_COLOR >> 16) & 0xff),
+		A: uint8((BG_COLOR >> 24) & 0xff),
+	}
+
+	pix := r.image.Pix
+	for i := 0; i < len(pix); i += 4 {
+		pix[i] = bg.R
+		pix[i+1] = bg.G
+		pix[i+2] = bg.B
+		pix[i+3] = bg.A
+	}
+
+	for i := 0; i < len(r.depthBuffer); i++ {
+		r.depthBuffer[i] = math.MaxFloat64
+	}
+}
+
+// Unproject returns the view space position of the surface drawn at the pixel, if there is any.
+func (r *Renderer) Unproject(x, y int) (Vector4, bool) {
+	if x < 0 || y < 0 || x >= r.width || y >= r.height {
+		return Vector4{}, false
+	}
+
+	z := r.depthBuffer[y*r.width+x]
+	if z == math.MaxFloat64 {
+		return Vector4{}, false
+	}
+
+	return r.viewPosition(float64(x)+0.5, float64(y)+0.5, z), true
+}
+
+// viewPosition returns the view space position of the screen point at view space depth z.
+func (r *Renderer) viewPosition(x, y, z float64) Vector4 {
+	// Undo the screen offset and the perspective divide, where w is -z
+	ndcX := x/r.widthHalf - 1
+	ndcY := y/r.heightHalf - 1
+
+	return NewVector4(-ndcX*z/r.projection.m[0][0], -ndcY*z/r.projection.m[1][1], z, 1)
+}
+
+// DrawMesh transforms, clips and rasterizes every triangle of the mesh using the given world matrix.
+// The vertices are transformed once into the vertex cache, then the triangles of each submesh
+// are projected in parallel batches, binned into screen tiles and rasterized in parallel.
+// Meshes without faces are drawn as point clouds.
+func (r *Renderer) DrawMesh(mesh *Mesh, worldMatrix mat44) {
+	r.transformVertices(mesh, worldMatrix)
+	r.prepareLights(mesh, worldMatrix)
+
+	if mesh.isPointCloud() {
+		r.drawPoints(mesh)
+		return
+	}
+	if r.shadows {
+		r.renderShadowMaps(mesh, worldMatrix)
+	}
+
+	nBatches := 0
+	for s := range mesh.submeshes {
+		triangles := len(mesh.submeshes[s].indices) / 3
+		for start := 0; start < triangles; start += PROJECT_BATCH_SIZE {
+			if nBatches == len(r.batches) {
+				r.batches = append(r.batches, projectedBatch{})
+			}
+
+			batch := &r.batches[nBatches]
+			batch.submesh = &mesh.submeshes[s]
+			batch.start, batch.end = start, min(start+PROJECT_BATCH_SIZE, triangles)
+			nBatches++
+		}
+	}
+
+	r.parallel(nBatches, func(i int) {
+		batch := &r.batches[i]
+		batch.tris = batch.tris[:0]
+		batch.facing = batch.facing[:0]
+
+		for n := batch.start; n < batch.end; n++ {
+			r.projectTriangle(batch.submesh, n, batch)
+		}
+	})
+
+	// Queue the edges and bin the faces in the mesh order, so translucent faces blend the same way
+	// as when drawn one by one. The hidden line mode only draws the faces into the depth buffer, to
+	// hide the edges behind them.
+	seenEdges := make(map[edgeKey]bool)
+	for i := range r.tiles {
+		r.tiles[i].tris = r.tiles[i].tris[:0]
+		r.tiles[i].points = r.tiles[i].points[:0]
+	}
+
+	for i := 0; i < nBatches; i++ {
+		batch := &r.batches[i]
+		for n := range batch.tris {
+			if r.mode.lines() {
+				r.addEdges(&batch.tris[n], seenEdges)
+			}
+			if (batch.facing[n] || r.depthOnly) && r.mode != RENDER_WIREFRAME {
+				r.binTriangle(&batch.tris[n])
+			}
+		}
+	}
+
+	r.parallel(len(r.tiles), func(i int) {
+		r.rasterizeTile(&r.tiles[i])
+	})
+
+	if r.ssao && r.mode.fills() {
+		r.applyAmbientOcclusion(mesh)
+	}
+	if r.mode.lines() {
+		r.drawEdges()
+	}
+}
+
+// transformVertices fills the vertex cache with the mesh vertices in view space, so the vertices
+// shared by several triangles are only transformed once.
+func (r *Renderer) transformVertices(mesh *Mesh, worldMatrix mat44) {
+	if cap(r.viewVertices) < len(mesh.positions) {
+		r.viewVertices = make([]Vector4, len(mesh.positions))
+	}
+	r.viewVertices = r.viewVertices[:len(mesh.positions)]
+
+	nBatches := (len(mesh.positions) + PROJECT_BATCH_SIZE - 1) / PROJECT_BATCH_SIZE
+	r.parallel(nBatches, func(i int) {
+		end := min((i+1)*PROJECT_BATCH_SIZE, len(mesh.positions))
+		for n := i * PROJECT_BATCH_SIZE; n < end; n++ {
+			v := worldMatrix.multiplyVector(mesh.vertex(int32(n)))
+			v.originalZ = v.z
+			v.normVec = worldMatrix.multiplyNormal(v.normVec).Normalise()
+			r.viewVertices[n] = v
+		}
+	})
+}
+
+// projectTriangle lights and projects the triangle n of the submesh to screen space, adding the
+// result to the batch if it faces the camera (or for its edges, in wireframe mode). Handles near
+// clipping.
+func (r *Renderer) projectTriangle(submesh *Submesh, n int, batch *projectedBatch) {
+	triTransformed := Triangle{material: submesh.material, vertexColors: submesh.vertexColors}
+
+	// Light the vertices when doing Gouraud shading
+	for i := range triTransformed.vecs {
+		v := &triTransformed.vecs[i]
+		*v = r.viewVertices[submesh.indices[3*n+i]]
+		if r.shading == SHADING_GOURAUD {
+			v.ilum, v.spec = r.lightAt(*v, v.normVec, triTransformed.material)
+		}
+	}
+
+	if r.shading == SHADING_PHONG && (triTransformed.material.bumpMap != nil || triTransformed.material.normalMap != nil) {
+		triTransformed.tangent, triTransformed.bitangent = triangleTangents(&triTransformed)
+	}
+
+	// Calculate the normal of the triangle face
+	line1 := triTransformed.vecs[1].Sub(triTransformed.vecs[0])
+	line2 := triTransformed.vecs[2].Sub(triTransformed.vecs[0])
+	normal := line1.CrossProduct(line2).Normalise()
+
+	cameraRay := triTransformed.vecs[0].Sub(r.camera)
+
+	// The wireframe mode also shows the edges of the back faces, and shadow maps need both sides
+	facing := (normal.Dot(cameraRay) < 0 && !r.flipNormals) || (normal.Dot(cameraRay) > 0 && r.flipNormals)
+	if !facing && r.mode != RENDER_WIREFRAME && !r.depthOnly {
+		return
+	}
+
+	if r.shading == SHADING_FLAT {
+		centroid := triTransformed.vecs[0].Add(triTransformed.vecs[1]).Add(triTransformed.vecs[2]).Div(3)
+		triTransformed.ilum, triTransformed.spec = r.lightAt(centroid, NewNormalVector(normal.x, normal.y, normal.z), triTransformed.material)
+	}
+
+	// Transform and project triangles
+	clipped := ClipAgainstPlane(NewVector4(0, 0, 0.1, 1), NewVector4(0, 0, 1, 1), triTransformed)
+	for n := 0; n < len(clipped); n++ {
+		// Project triangles to 2D
+		triProjected := r.projection.multiplyTriangle(clipped[n])
+
+		// Apply depth
+		triProjected.vecs[0].texVec.u /= triProjected.vecs[0].w
+		triProjected.vecs[1].texVec.u /= triProjected.vecs[1].w
+		triProjected.vecs[2].texVec.u /= triProjected.vecs[2].w
+		triProjected.vecs[0].texVec.v /= triProjected.vecs[0].w
+		triProjected.vecs[1].texVec.v /= triProjected.vecs[1].w
+		triProjected.vecs[2].texVec.v /= triProjected.vecs[2].w
+		triProjected.vecs[0].texVec.w = 1 / triProjected.vecs[0].w
+		triProjected.vecs[1].texVec.w = 1 / triProjected.vecs[1].w
+		triProjected.vecs[2].texVec.w = 1 / triProjected.vecs[2].w
+
+		// Normals, vertex illumination and colors are also interpolated with perspective correction
+		for i := range triProjected.vecs {
+			v := &triProjected.vecs[i]
+			v.normVec.x /= v.w
+			v.normVec.y /= v.w
+			v.normVec.z /= v.w
+			v.ilum = v.ilum.Scale(1 / v.w)
+			v.spec = v.spec.Scale(1 / v.w)
+			v.color = v.color.Scale(1 / v.w)
+		}
+
+		triProjected.vecs[0] = triProjected.vecs[0].Div(triProjected.vecs[0].w)
+		triProjected.vecs[1] = triProjected.vecs[1].Div(triProjected.vecs[1].w)
+		triProjected.vecs[2] = triProjected.vecs[2].Div(triProjected.vecs[2].w)
+
+		// Offset into view
+		vOffsetView := NewVector4(1, 1, 0, 0)
+		triProjected.vecs[0] = triProjected.vecs[0].Add(vOffsetView)
+		triProjected.vecs[1] = triProjected.vecs[1].Add(vOffsetView)
+		triProjected.vecs[2] = triProjected.vecs[2].Add(vOffsetView)
+
+		triProjected.vecs[0].originalZ = triTransformed.vecs[0].originalZ
+		triProjected.vecs[1].originalZ = triTransformed.vecs[1].originalZ
+		triProjected.vecs[2].originalZ = triTransformed.vecs[2].originalZ
+
+		// Expand to screen size
+		triProjected.vecs[0].x *= r.widthHalf
+		triProjected.vecs[0].y *= r.heightHalf
+		triProjected.vecs[1].x *= r.widthHalf
+		triProjected.vecs[1].y *= r.heightHalf
+		triProjected.vecs[2].x *= r.widthHalf
+		triProjected.vecs[2].y *= r.heightHalf
+
+		batch.tris = append(batch.tris, triProjected)
+		batch.facing = append(batch.facing, facing)
+	}
+}
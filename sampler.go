@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+)
+
+type TextureFilter int
+
+const (
+	FILTER_NEAREST   TextureFilter = iota // Closest texel of the full size image
+	FILTER_BILINEAR                       // Blend of the 4 closest texels, in the closest mip level
+	FILTER_TRILINEAR                      // Blend of bilinear samples of the 2 closest mip levels
+)
+
+var textureFilterNames = []string{"Nearest", "Bilinear", "Trilinear"}
+
+func (f TextureFilter) String() string {
+	return textureFilterNames[f]
+}
+
+func ParseTextureFilter(name string) (TextureFilter, error) {
+	for i, filterName := range textureFilterNames {
+		if strings.EqualFold(name, filterName) {
+			return TextureFilter(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown texture filter '%s'", name)
+}
+
+// How texture coordinates outside of the 0 to 1 range are handled
+type WrapMode int
+
+const (
+	WRAP_REPEAT WrapMode = iota
+	WRAP_CLAMP           // Repeats the border texels
+	WRAP_MIRROR          // Repeats the texture, flipping every other copy
+)
+
+// buildMipLevels halves the image size, averaging each 2x2 block of texels, until it is 1x1.
+func buildMipLevels(base textureLevel) []textureLevel {
+	levels := []textureLevel{base}
+
+	for cur := base; cur.w > 1 || cur.h > 1; {
+		next := textureLevel{w: max(1, cur.w/2), h: max(1, cur.h/2)}
+		next.data = make([]color.RGBA, next.w*next.h)
+
+		for y := 0; y < next.h; y++ {
+			// Odd sizes reuse the last row or column
+			y0, y1 := min(y*2, cur.h-1), min(y*2+1, cur.h-1)
+			for x := 0; x < next.w; x++ {
+				x0, x1 := min(x*2, cur.w-1), min(x*2+1, cur.w-1)
+
+				c00, c10 := cur.data[y0*cur.w+x0], cur.data[y0*cur.w+x1]
+				c01, c11 := cur.data[y1*cur.w+x0], cur.data[y1*cur.w+x1]
+
+				next.data[y*next.w+x] = color.RGBA{
+					uint8((int(c00.R) + int(c10.R) + int(c01.R) + int(c11.R) + 2) / 4),
+					uint8((int(c00.G) + int(c10.G) + int(c01.G) + int(c11.G) + 2) / 4),
+					uint8((int(c00.B) + int(c10.B) + int(c01.B) + int(c11.B) + 2) / 4),
+					uint8((int(c00.A) + int(c10.A) + int(c01.A) + int(c11.A) + 2) / 4),
+				}
+			}
+		}
+
+		levels = append(levels, next)
+		cur = next
+	}
+
+	return levels
+}
+
+func wrapTexel(i, size int, mode WrapMode) int {
+	switch mode {
+	case WRAP_CLAMP:
+		return min(max(i, 0), size-1)
+	case WRAP_MIRROR:
+		i = ((i % (2 * size)) + 2*size) % (2 * size)
+		if i >= size {
+			return 2*size - 1 - i
+		}
+		return i
+	default:
+		return ((i % size) + size) % size
+	}
+}
+
+func (t *Texture) texel(level *textureLevel, x, y int) color.RGBA {
+	return level.data[wrapTexel(y, level.h, t.wrapV)*level.w+wrapTexel(x, level.w, t.wrapU)]
+}
+
+// sampleLevel returns the bilinear filtered color of the mip level, as float components.
+func (t *Texture) sampleLevel(level *textureLevel, u, v float64) [4]float64 {
+	// Texel centers are at half integer coordinates, and v grows upwards
+	x := u*float64(level.w) - 0.5
+	y := (1-v)*float64(level.h) - 0.5
+
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	ix, iy := int(x0), int(y0)
+
+	c00, c10 := t.texel(level, ix, iy), t.texel(level, ix+1, iy)
+	c01, c11 := t.texel(level, ix, iy+1), t.texel(level, ix+1, iy+1)
+
+	lerp := func(a, b, c, d uint8) float64 {
+		top := float64(a)*(1-fx) + float64(b)*fx
+		bottom := float64(c)*(1-fx) + float64(d)*fx
+		return top*(1-fy) + bottom*fy
+	}
+
+	return [4]float64{
+		lerp(c00.R, c10.R, c01.R, c11.R),
+		lerp(c00.G, c10.G, c01.G, c11.G),
+		lerp(c00.B, c10.B, c01.B, c11.B),
+		lerp(c00.A, c10.A, c01.A, c11.A),
+	}
+}
+
+// Sample returns the texture color at the given coordinates. The footprint is the squared
+// distance in texture coordinates between neighbouring pixels, used to pick the mip level.
+func (t *Texture) Sample(u, v, footprint float64, filter TextureFilter) color.RGBA {
+	if len(t.levels) == 0 || t.levels[0].w == 0 || t.levels[0].h == 0 {
+		return color.RGBA{}
+	}
+
+	if filter == FILTER_NEAREST {
+		level := &t.levels[0]
+		return t.texel(level, int(math.Floor(u*float64(level.w))), int(math.Floor((1-v)*float64(level.h))))
+	}
+
+	// Level of detail, where each level halves the texture size
+	lod := 0.0
+	if footprint > 0 {
+		lod = 0.5*math.Log2(footprint) + math.Log2(math.Max(t.w, t.h))
+	}
+	lod = math.Max(0, math.Min(lod, float64(len(t.levels)-1)))
+
+	var c [4]float64
+	if filter == FILTER_BILINEAR {
+		c = t.sampleLevel(&t.levels[int(math.Round(lod))], u, v)
+	} else {
+		lower := int(math.Floor(lod))
+		upper := min(lower+1, len(t.levels)-1)
+		blend := lod - float64(lower)
+
+		c0 := t.sampleLevel(&t.levels[lower], u, v)
+		c1 := t.sampleLevel(&t.levels[upper], u, v)
+		for i := range c {
+			c[i] = c0[i]*(1-blend) + c1[i]*blend
+		}
+	}
+
+	return color.RGBA{uint8(c[0] + 0.5), uint8(c[1] + 0.5), uint8(c[2] + 0.5), uint8(c[3] + 0.5)}
+}
+
+// SampleValue returns the texture as a scalar (e.g. for alpha and bump maps), between 0 and 1.
+// It uses the alpha channel of images with transparency, and the luminance otherwise.
+func (t *Texture) SampleValue(u, v, footprint float64, filter TextureFilter) float64 {
+	c := t.Sample(u, v, footprint, filter)
+	if t.hasAlpha {
+		return float64(c.A) / 255
+	}
+
+	return (0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)) / 255
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+)
+
+type ShadingMode int
+
+const (
+	SHADING_FLAT    ShadingMode = iota // One illumination value per triangle, from its face normal
+	SHADING_GOURAUD                    // Illumination computed at the vertices and interpolated
+	SHADING_PHONG                      // Vertex normals interpolated and illumination computed per pixel
+)
+
+var shadingModeNames = []string{"Flat", "Gouraud", "Phong"}
+
+func (m ShadingMode) String() string {
+	return shadingModeNames[m]
+}
+
+func ParseShadingMode(name string) (ShadingMode, error) {
+	for i, modeName := range shadingModeNames {
+		if strings.EqualFold(name, modeName) {
+			return ShadingMode(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown shading mode '%s'", name)
+}
+
+const (
+	AMBIENT_LIGHT float64 = 0.1 // Default ambient illumination, so faces in the shadow are still visible
+	BUMP_SCALE    float64 = 4   // Strength of bump maps, before the material's bump multiplier
+)
+
+// lightAt returns the diffuse and specular (Blinn-Phong) illumination of every light on a surface
+// at the view space position, with the given normalised normal.
+func (r *Renderer) lightAt(position Vector4, normal NormalVector, material *Material) (ColorVector, ColorVector) {
+	var diffuse, specular ColorVector
+	hasSpecular := material.illum >= 2 && (!material.specular.IsZero() || material.specularMap != nil)
+
+	// Direction towards the camera, at the origin
+	viewLength := math.Sqrt(position.x*position.x + position.y*position.y + position.z*position.z)
+	viewX, viewY, viewZ := -position.x/viewLength, -position.y/viewLength, -position.z/viewLength
+
+	for i := range r.viewLights {
+		l := &r.viewLights[i]
+		radiance := l.radiance
+
+		// Direction towards the light
+		toX, toY, toZ := -l.direction.x, -l.direction.y, -l.direction.z
+		if l.lightType != LIGHT_DIRECTIONAL {
+			toX, toY, toZ = l.position.x-position.x, l.position.y-position.y, l.position.z-position.z
+			distanceSquared := toX*toX + toY*toY + toZ*toZ
+			distance := math.Sqrt(distanceSquared)
+			toX, toY, toZ = toX/distance, toY/distance, toZ/distance
+			attenuation := 1 / (1 + l.attenuation*distanceSquared)
+
+			if l.lightType == LIGHT_SPOT {
+				cosAngle := -(toX*l.direction.x + toY*l.direction.y + toZ*l.direction.z)
+				if cosAngle <= l.cosOuter {
+					continue
+				}
+				if cosAngle < l.cosInner {
+					edge := (cosAngle - l.cosOuter) / (l.cosInner - l.cosOuter)
+					attenuation *= edge * edge * (3 - 2*edge)
+				}
+			}
+			radiance = radiance.Scale(attenuation)
+		}
+
+		lambert := normal.x*toX + normal.y*toY + normal.z*toZ
+		if lambert <= 0 {
+			continue
+		}
+		if l.shadow != nil {
+			visibility := l.shadow.visibility(position, lambert, r.shadowBias)
+			if visibility == 0 {
+				continue
+			}
+			radiance = radiance.Scale(visibility)
+		}
+		diffuse = diffuse.Add(radiance.Scale(lambert))
+
+		if hasSpecular {
+			half := NewNormalVector(toX+viewX, toY+viewY, toZ+viewZ).Normalise()
+			specular = specular.Add(radiance.Scale(math.Pow(math.Max(0, normal.DotNormal(half)), math.Max(1, material.shininess))))
+		}
+	}
+
+	return diffuse, specular
+}
+
+// triangleTangents returns the directions in which the texture u and v coordinates grow
+// across the triangle.
+func triangleTangents(t *Triangle) (NormalVector, NormalVector) {
+	e1 := t.vecs[1].Sub(t.vecs[0])
+	e2 := t.vecs[2].Sub(t.vecs[0])
+
+	du1, dv1 := t.vecs[1].texVec.u-t.vecs[0].texVec.u, t.vecs[1].texVec.v-t.vecs[0].texVec.v
+	du2, dv2 := t.vecs[2].texVec.u-t.vecs[0].texVec.u, t.vecs[2].texVec.v-t.vecs[0].texVec.v
+
+	det := du1*dv2 - du2*dv1
+	if det == 0 {
+		return NormalVector{}, NormalVector{}
+	}
+	f := 1 / det
+
+	tangent := NewNormalVector((e1.x*dv2-e2.x*dv1)*f, (e1.y*dv2-e2.y*dv1)*f, (e1.z*dv2-e2.z*dv1)*f)
+	bitangent := NewNormalVector((e2.x*du1-e1.x*du2)*f, (e2.y*du1-e1.y*du2)*f, (e2.z*du1-e1.z*du2)*f)
+
+	return tangent, bitangent
+}
+
+// perturbNormal applies the normal or bump map of the material to the (normalised) interpolated normal.
+func (r *Renderer) perturbNormal(normal NormalVector, tri *Triangle, tex TexVector) NormalVector {
+	if tri.tangent.IsZero() || tri.bitangent.IsZero() {
+		return normal
+	}
+
+	// Make the tangent frame orthogonal to the interpolated normal
+	tangent := tri.tangent.Add(normal.Scale(-normal.DotNormal(tri.tangent))).Normalise()
+	bitangent := tri.bitangent.Add(normal.Scale(-normal.DotNormal(tri.bitangent))).Add(tangent.Scale(-tangent.DotNormal(tri.bitangent))).Normalise()
+
+	m := tri.material
+	u, v := tex.u, tex.v
+	if m.normalMap != nil {
+		c := m.normalMap.Sample(u, v, tex.footprint, r.filter)
+		x := float64(c.R)/127.5 - 1
+		y := float64(c.G)/127.5 - 1
+		z := float64(c.B)/127.5 - 1
+
+		return tangent.Scale(x).Add(bitangent.Scale(y)).Add(normal.Scale(z)).Normalise()
+	}
+
+	// Bump map: tilt the normal against the height slope
+	du, dv := 1/m.bumpMap.w, 1/m.bumpMap.h
+	height := m.bumpMap.SampleValue(u, v, tex.footprint, r.filter)
+	dhdu := (m.bumpMap.SampleValue(u+du, v, tex.footprint, r.filter) - height) * BUMP_SCALE * m.bumpMultiplier
+	dhdv := (m.bumpMap.SampleValue(u, v+dv, tex.footprint, r.filter) - height) * BUMP_SCALE * m.bumpMultiplier
+
+	return normal.Add(tangent.Scale(-dhdu)).Add(bitangent.Scale(-dhdv)).Normalise()
+}
+
+func colorFromRGBA(c color.RGBA) ColorVector {
+	return NewColorVector(float64(c.R)/255, float64(c.G)/255, float64(c.B)/255)
+}
+
+func clampColorComponent(value float64) uint8 {
+	return uint8(math.Min(1, math.Max(0, value)) * 255)
+}
+
+// shade computes the color of a pixel of the triangle from its material and the illumination.
+// It returns false if the pixel is fully transparent.
+func (r *Renderer) shade(p *Vector4, tri *Triangle) (color.RGBA, bool) {
+	m := tri.material
+	u, v := p.texVec.u, p.texVec.v
+
+	base := m.diffuse
+	alpha := m.opacity
+	if m.diffuseMap != nil {
+		texel := m.diffuseMap.Sample(u, v, p.texVec.footprint, r.filter)
+		base = colorFromRGBA(texel)
+		alpha *= float64(texel.A) / 255
+	}
+	if tri.vertexColors {
+		base = base.Mul(p.color)
+	}
+	if m.alphaMap != nil {
+		alpha *= m.alphaMap.SampleValue(u, v, p.texVec.footprint, r.filter)
+	}
+
+	if alpha <= 0 {
+		return color.RGBA{}, false
+	}
+
+	var diffuse, specular ColorVector
+	switch r.shading {
+	case SHADING_FLAT:
+		diffuse, specular = tri.ilum, tri.spec
+	case SHADING_GOURAUD:
+		diffuse, specular = p.ilum, p.spec
+	case SHADING_PHONG:
+		normal := p.normVec.Normalise()
+		if m.normalMap != nil || m.bumpMap != nil {
+			normal = r.perturbNormal(normal, tri, p.texVec)
+		}
+		diffuse, specular = r.lightAt(r.viewPosition(p.x+0.5, p.y+0.5, r.exactDepth(p)), normal, m)
+	}
+
+	final := base
+	if m.illum > 0 {
+		final = base.Mul(m.ambient.Mul(r.ambient)).Add(base.Mul(diffuse))
+
+		if !specular.IsZero() {
+			specularColor := m.specular
+			if m.specularMap != nil {
+				specularColor = specularColor.Mul(colorFromRGBA(m.specularMap.Sample(u, v, p.texVec.footprint, r.filter)))
+			}
+			final = final.Add(specularColor.Mul(specular))
+		}
+	}
+	final = final.Add(m.emissive)
+
+	return color.RGBA{
+		clampColorComponent(final.r),
+		clampColorComponent(final.g),
+		clampColorComponent(final.b),
+		clampColorComponent(alpha),
+	}, true
+}
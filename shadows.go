@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+)
+
+const (
+	SHADOW_MAP_SIZE     int     = 1024
+	SHADOW_PCF_RADIUS   int     = 1   // Texels sampled around the lookup in each direction, for soft edges
+	SHADOW_MAX_SLOPE    float64 = 5   // Largest bias scale of surfaces at grazing angles to the light
+	DEFAULT_SHADOW_BIAS float64 = 1.5 // In shadow map texels
+	MAX_SPOT_SHADOW_FOV float64 = 170 // Degrees, wider spot cones are clamped
+)
+
+// shadowMap holds the depths of the surfaces closest to a light, rendered from its point of view
+// by a depth only renderer.
+type shadowMap struct {
+	renderer     *Renderer
+	view         mat44   // From the camera view space into the light view space
+	texelSize    float64 // Of a shadow map texel, in view space units at any depth when orthographic, and at unit depth otherwise
+	orthographic bool    // For directional lights
+}
+
+// orthographicMatrix projects the view space square of the given half size onto the screen,
+// without perspective but with the same orientation as projectionMatrix.
+func orthographicMatrix(halfSize float64) mat44 {
+	return mat44{
+		m: [4][4]float64{
+			{-1 / halfSize, 0, 0, 0},
+			{0, -1 / halfSize, 0, 0},
+			{0, 0, 1, 0},
+			{0, 0, 0, 1},
+		},
+	}
+}
+
+// renderShadowMaps renders the shadow map of every directional and spot light casting shadows,
+// after prepareLights. Point lights would need one map per direction, so they cast none.
+func (r *Renderer) renderShadowMaps(mesh *Mesh, worldMatrix mat44) {
+	// Bounding sphere of the mesh in view space, which the directional light maps cover
+	center, radius := mesh.boundingSphere()
+	center = worldMatrix.multiplyVector(center)
+
+	used := 0
+	for i := range r.viewLights {
+		l := &r.viewLights[i]
+		l.shadow = nil
+		if !r.lights[i].castShadows || l.lightType == LIGHT_POINT {
+			continue
+		}
+
+		if used == len(r.shadowMaps) {
+			shadowRenderer := NewRenderer(SHADOW_MAP_SIZE, SHADOW_MAP_SIZE)
+			shadowRenderer.depthOnly = true
+			shadowRenderer.lights = nil
+			shadowRenderer.workers = r.workers
+			r.shadowMaps = append(r.shadowMaps, shadowMap{renderer: shadowRenderer})
+		}
+		s := &r.shadowMaps[used]
+		used++
+
+		up := NewVector4(0, 1, 0, 0)
+		if math.Abs(l.direction.y) > 0.99 {
+			up = NewVector4(1, 0, 0, 0)
+		}
+
+		if l.lightType == LIGHT_DIRECTIONAL {
+			// Far enough for the whole mesh to be in front of the near plane
+			eye := center.Sub(l.direction.Mul(radius + 1))
+			s.view = lookAtMatrix(eye, eye.Add(l.direction), up)
+			s.renderer.projection = orthographicMatrix(radius)
+			s.texelSize = 2 * radius / float64(SHADOW_MAP_SIZE)
+			s.orthographic = true
+		} else {
+			fov := math.Min(2*radToDeg(r.lights[i].coneAngle), MAX_SPOT_SHADOW_FOV)
+			s.view = lookAtMatrix(l.position, l.position.Add(l.direction), up)
+			s.renderer.projection = projectionMatrix(1, fov, NEAR_DISTANCE, FAR_DISTANCE)
+			s.texelSize = 2 * math.Tan(degToRad(fov/2)) / float64(SHADOW_MAP_SIZE)
+			s.orthographic = false
+		}
+
+		s.renderer.Clear()
+		s.renderer.DrawMesh(mesh, worldMatrix.multiplyMatrix(s.view))
+		l.shadow = s
+	}
+}
+
+// visibility returns the fraction of the light reaching the view space position, averaging the
+// shadow map tests of the texels around it (percentage closer filtering). lambert is the cosine
+// of the angle between the surface normal and the light, as surfaces at grazing angles need a
+// larger bias to avoid shadowing themselves.
+func (s *shadowMap) visibility(position Vector4, lambert, bias float64) float64 {
+	l := s.view.multiplyVector(position)
+	if l.z <= NEAR_DISTANCE {
+		return 1
+	}
+
+	m := s.renderer
+	projected := m.projection.multiplyVector(l)
+	x := (projected.x/projected.w + 1) * m.widthHalf
+	y := (projected.y/projected.w + 1) * m.heightHalf
+
+	texel := s.texelSize
+	if !s.orthographic {
+		texel *= l.z
+	}
+	slope := math.Min(math.Sqrt(math.Max(0, 1-lambert*lambert))/math.Max(lambert, 1e-6), SHADOW_MAX_SLOPE)
+	depth := l.z - bias*texel*(1+slope)
+
+	cx, cy := int(math.Floor(x)), int(math.Floor(y))
+	lit, samples := 0, 0
+	for py := cy - SHADOW_PCF_RADIUS; py <= cy+SHADOW_PCF_RADIUS; py++ {
+		for px := cx - SHADOW_PCF_RADIUS; px <= cx+SHADOW_PCF_RADIUS; px++ {
+			samples++
+			if px < 0 || py < 0 || px >= m.width || py >= m.height || depth <= m.depthBuffer[py*m.width+px] {
+				lit++
+			}
+		}
+	}
+
+	return float64(lit) / float64(samples)
+}
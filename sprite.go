@@ -1,72 +1,90 @@
 package main
 
 import (
-	"fmt"
 	"image"
 	"image/color"
-	"image/jpeg"
-	"image/png"
+	_ "image/jpeg" // Texture formats, registered for image.Decode
+	_ "image/png"
+	"io"
 	"os"
-
-	"math"
-
-	"github.com/ncruces/zenity"
 )
 
 type Texture struct {
-	w, h float64
-	data [][]color.RGBA
-}
+	w, h     float64
+	levels   []textureLevel // Mip chain, from the full size image down to 1x1
+	hasAlpha bool           // Whether any pixel is not fully opaque
 
-func (t *Texture) GetColorAt(u, v float64) color.RGBA {
-	finalU := u - math.Floor(u)
-	finalV := v - math.Floor(v)
-	x := int(math.Round((t.w - 1) * finalU))
-	y := int((t.h - 1) - math.Round((t.h-1)*finalV))
+	wrapU, wrapV WrapMode
 
-	return t.data[y][x]
+	source string // File the image was loaded from, empty when embedded in the model
 }
 
-func LoadTexture(filename string) *Texture {
-	image.RegisterFormat("png", "png", png.Decode, png.DecodeConfig)
-	image.RegisterFormat("jpeg", "jpg", jpeg.Decode, jpeg.DecodeConfig)
+type textureLevel struct {
+	w, h int
+	data []color.RGBA
+}
 
-	file, err := os.Open(filename)
+// WithWrap returns a copy of the texture, sharing its image data, using the given wrap modes.
+func (t *Texture) WithWrap(wrapU, wrapV WrapMode) *Texture {
+	texture := *t
+	texture.wrapU = wrapU
+	texture.wrapV = wrapV
 
+	return &texture
+}
+
+func LoadTexture(filename string) (*Texture, error) {
+	file, err := os.Open(filename)
 	if err != nil {
-		zenity.Error(fmt.Sprintf("Error loading the texture '%s'.\n%s", filename, err), zenity.Title("Texture load error"), zenity.ErrorIcon)
-		panic(err)
+		return nil, err
 	}
 
 	defer file.Close()
 
-	texture := Texture{}
+	texture, err := DecodeTexture(file)
+	if err != nil {
+		return nil, err
+	}
+	texture.source = filename
 
-	img, _, err := image.Decode(file)
+	return texture, nil
+}
 
+// DecodeTexture reads a PNG or JPEG image, e.g. one embedded in a model file.
+func DecodeTexture(reader io.Reader) (*Texture, error) {
+	img, _, err := image.Decode(reader)
 	if err != nil {
-		zenity.Error(fmt.Sprintf("Error decoding the texture image.\n%s", err), zenity.Title("Texture load error"), zenity.ErrorIcon)
-		panic(err)
+		return nil, err
 	}
 
+	return NewTexture(img), nil
+}
+
+func NewTexture(img image.Image) *Texture {
 	bounds := img.Bounds()
 
-	w, h := bounds.Max.X, bounds.Max.Y
-	texture.w = float64(w)
-	texture.h = float64(h)
+	base := textureLevel{w: bounds.Dx(), h: bounds.Dy()}
+	base.data = make([]color.RGBA, 0, base.w*base.h)
+
+	texture := Texture{}
+	texture.w = float64(base.w)
+	texture.h = float64(base.h)
 
-	for y := 0; y < h; y++ {
-		row := []color.RGBA{}
-		for x := 0; x < w; x++ {
-			r, g, b, a := img.At(x, y).RGBA()
+	for y := 0; y < base.h; y++ {
+		for x := 0; x < base.w; x++ {
+			r, g, b, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
 			r /= 257
 			g /= 257
 			b /= 257
 			a /= 257
-			row = append(row, color.RGBA{uint8(r), uint8(g), uint8(b), uint8(a)})
+			if a < 255 {
+				texture.hasAlpha = true
+			}
+			base.data = append(base.data, color.RGBA{uint8(r), uint8(g), uint8(b), uint8(a)})
 		}
-		texture.data = append(texture.data, row)
 	}
 
+	texture.levels = buildMipLevels(base)
+
 	return &texture
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"math"
+)
+
+const (
+	DEFAULT_SSAO_RADIUS  float64 = 0.1 // In model radii, so it suits models of any scale
+	DEFAULT_SSAO_SAMPLES int     = 16
+	DEFAULT_SSAO_BLUR    int     = 2    // Pixels blurred on each side, removing the noise of the rotated samples
+	SSAO_STRENGTH        float64 = 1.5  // Darkening of fully occluded pixels, before clamping
+	SSAO_DEPTH_BIAS      float64 = 0.02 // Fraction of the radius, avoids flat surfaces occluding themselves
+)
+
+// Sample rotations of each pixel in a 4x4 block, in sixteenths of a turn, interleaved so that the
+// neighbouring pixels use very different ones
+var SSAO_ROTATIONS = [16]float64{0, 8, 2, 10, 12, 4, 14, 6, 3, 11, 1, 9, 15, 7, 13, 5}
+
+// ssaoKernel returns the offsets of the samples in a unit hemisphere around +z, spread with the
+// golden angle and denser close to the center, where the occluders matter most.
+func ssaoKernel(samples int) []Vector4 {
+	kernel := make([]Vector4, samples)
+	goldenAngle := math.Pi * (3 - math.Sqrt(5))
+	for k := range kernel {
+		u := (float64(k) + 0.5) / float64(samples)
+		angle := float64(k) * goldenAngle
+		spread := math.Sqrt(u)
+
+		scale := float64(k+1) / float64(samples)
+		scale = 0.1 + 0.9*scale*scale
+		kernel[k] = NewVector4(spread*math.Cos(angle)*scale, spread*math.Sin(angle)*scale, math.Sqrt(1-u)*scale, 0)
+	}
+
+	return kernel
+}
+
+// applyAmbientOcclusion darkens the rendered pixels by how much the surfaces around them hide
+// the sky, estimated from the depth buffer alone (screen space ambient occlusion), so cavities
+// and crevices become readable. It runs on the image at the render resolution, before the edges
+// are drawn over it and before the viewer upscales it to the window.
+func (r *Renderer) applyAmbientOcclusion(mesh *Mesh) {
+	_, meshRadius := mesh.boundingSphere()
+	radius := r.ssaoRadius * meshRadius
+	samples := max(r.ssaoSamples, 1)
+	if radius <= 0 {
+		return
+	}
+
+	if len(r.ssaoKernel) != samples {
+		r.ssaoKernel = ssaoKernel(samples)
+	}
+	if len(r.occlusion) != r.width*r.height {
+		r.occlusion = make([]float64, r.width*r.height)
+		r.occlusionBlur = make([]float64, r.width*r.height)
+	}
+
+	r.parallel(r.height, func(y int) {
+		for x := 0; x < r.width; x++ {
+			r.occlusion[y*r.width+x] = r.occlusionAt(x, y, radius)
+		}
+	})
+
+	// Separable blur, only between pixels of the same surface so the edges stay sharp
+	r.blurOcclusion(r.occlusion, r.occlusionBlur, 1, 0, radius)
+	r.blurOcclusion(r.occlusionBlur, r.occlusion, 0, 1, radius)
+
+	r.parallel(r.height, func(y int) {
+		for x := 0; x < r.width; x++ {
+			idx := y*r.width + x
+			if r.depthBuffer[idx] == math.MaxFloat64 {
+				continue
+			}
+
+			visibility := math.Max(0, 1-SSAO_STRENGTH*r.occlusion[idx])
+			pix := r.image.Pix[idx*4 : idx*4+3]
+			pix[0] = uint8(float64(pix[0]) * visibility)
+			pix[1] = uint8(float64(pix[1]) * visibility)
+			pix[2] = uint8(float64(pix[2]) * visibility)
+		}
+	})
+}
+
+// occlusionAt returns the fraction of the samples in the hemisphere above the surface drawn at the
+// pixel that are behind other surfaces.
+func (r *Renderer) occlusionAt(x, y int, radius float64) float64 {
+	z := r.depthBuffer[y*r.width+x]
+	if z == math.MaxFloat64 {
+		return 0
+	}
+
+	position := r.viewPosition(float64(x)+0.5, float64(y)+0.5, z)
+	normal, ok := r.depthNormal(x, y, position)
+	if !ok {
+		return 0
+	}
+
+	// Tangent space of the surface, rotated differently for each pixel of the 4x4 block
+	axis := NewVector4(0, 1, 0, 0)
+	if math.Abs(normal.y) > 0.9 {
+		axis = NewVector4(1, 0, 0, 0)
+	}
+	tangent := axis.CrossProduct(normal).Normalise()
+	bitangent := normal.CrossProduct(tangent)
+
+	rotation := SSAO_ROTATIONS[(y%4)*4+x%4] * 2 * math.Pi / 16
+	sin, cos := math.Sincos(rotation)
+
+	occlusion := 0.0
+	for _, k := range r.ssaoKernel {
+		kx, ky := k.x*cos-k.y*sin, k.x*sin+k.y*cos
+		sample := position.Add(tangent.Mul(kx * radius)).Add(bitangent.Mul(ky * radius)).Add(normal.Mul(k.z * radius))
+		if sample.z < NEAR_DISTANCE {
+			continue
+		}
+
+		// Project the sample back onto the screen, undoing viewPosition
+		sx := int(math.Floor((-sample.x*r.projection.m[0][0]/sample.z + 1) * r.widthHalf))
+		sy := int(math.Floor((-sample.y*r.projection.m[1][1]/sample.z + 1) * r.heightHalf))
+		if sx < 0 || sy < 0 || sx >= r.width || sy >= r.height {
+			continue
+		}
+
+		// Surfaces in front of the sample occlude it, unless they are much closer to the camera
+		// than the pixel, like the silhouette of another part far in front of it
+		occluder := r.depthBuffer[sy*r.width+sx]
+		if occluder < sample.z-SSAO_DEPTH_BIAS*radius {
+			occlusion += math.Min(1, radius/math.Abs(z-occluder))
+		}
+	}
+
+	return occlusion / float64(len(r.ssaoKernel))
+}
+
+// depthNormal reconstructs the normal of the surface drawn at the pixel from the positions of its
+// neighbours, on the side of each axis where the depth changes the least so the normals of the
+// silhouettes are not bent towards the surfaces behind them. The normal faces the camera.
+func (r *Renderer) depthNormal(x, y int, position Vector4) (Vector4, bool) {
+	neighbour := func(nx, ny int) (Vector4, bool) {
+		if nx < 0 || ny < 0 || nx >= r.width || ny >= r.height {
+			return Vector4{}, false
+		}
+		z := r.depthBuffer[ny*r.width+nx]
+		if z == math.MaxFloat64 {
+			return Vector4{}, false
+		}
+		return r.viewPosition(float64(nx)+0.5, float64(ny)+0.5, z), true
+	}
+
+	closest := func(ax, ay, bx, by int) (Vector4, bool) {
+		a, okA := neighbour(ax, ay)
+		b, okB := neighbour(bx, by)
+		switch {
+		case okA && (!okB || math.Abs(a.z-position.z) < math.Abs(b.z-position.z)):
+			return position.Sub(a), true
+		case okB:
+			return b.Sub(position), true
+		}
+		return Vector4{}, false
+	}
+
+	dx, okX := closest(x-1, y, x+1, y)
+	dy, okY := closest(x, y-1, x, y+1)
+	if !okX || !okY {
+		return Vector4{}, false
+	}
+
+	normal := dx.CrossProduct(dy)
+	if normal.Len() == 0 {
+		return Vector4{}, false
+	}
+	normal = normal.Normalise()
+	if normal.Dot(position) > 0 {
+		normal = normal.Mul(-1)
+	}
+
+	return normal, true
+}
+
+// blurOcclusion averages the occlusion of the pixels along the (dx, dy) direction, skipping the
+// ones whose depth differs by more than the radius.
+func (r *Renderer) blurOcclusion(src, dst []float64, dx, dy int, radius float64) {
+	r.parallel(r.height, func(y int) {
+		for x := 0; x < r.width; x++ {
+			idx := y*r.width + x
+			z := r.depthBuffer[idx]
+			if z == math.MaxFloat64 || r.ssaoBlur <= 0 {
+				dst[idx] = src[idx]
+				continue
+			}
+
+			sum, count := 0.0, 0
+			for i := -r.ssaoBlur; i <= r.ssaoBlur; i++ {
+				nx, ny := x+i*dx, y+i*dy
+				if nx < 0 || ny < 0 || nx >= r.width || ny >= r.height {
+					continue
+				}
+				n := ny*r.width + nx
+				if math.Abs(r.depthBuffer[n]-z) > radius {
+					continue
+				}
+				sum += src[n]
+				count++
+			}
+			dst[idx] = sum / float64(count)
+		}
+	})
+}
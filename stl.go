@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	STL_HEADER_SIZE int = 80
+	STL_FACET_SIZE  int = 50 // Normal and 3 vertices (12 float32), plus the 2 attribute bytes
+)
+
+func init() {
+	RegisterMeshFormat("STL", []string{".stl"}, "", ParseStl)
+	RegisterMeshEncoder("STL", []string{".stl"}, WriteStl)
+}
+
+// ParseStl reads a binary or ASCII .stl model into a mesh.
+func ParseStl(ctx context.Context, r io.Reader, filename string) (*Mesh, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := stlBuilder{vertices: make(map[stlVertexKey]int32)}
+	if isBinaryStl(data) {
+		err = parseBinaryStl(ctx, data, filename, &b)
+	} else {
+		err = parseAsciiStl(ctx, data, filename, &b)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Facets without a normal get their face normal
+	startPhase(ctx, LOAD_SMOOTHING, 0)
+	mesh := b.build()
+
+	mesh.faceAmount = b.triangles
+	mesh.triangleAmount = b.triangles
+	mesh.vertexAmount = countUniqueVertices(mesh.positions)
+
+	return mesh, nil
+}
+
+// stlBuilder shares the vertices of the facets with the same position and normal, which only
+// happens between coplanar ones, as the normals are given per facet.
+type stlBuilder struct {
+	meshBuilder
+	vertices map[stlVertexKey]int32
+}
+
+type stlVertexKey struct {
+	position [3]float64
+	normal   NormalVector
+}
+
+func (b *stlBuilder) addFacet(material *Material, v0, v1, v2 Vector4) {
+	var indices [3]int32
+	for i, v := range [3]Vector4{v0, v1, v2} {
+		if v.normVec.IsZero() {
+			indices[i] = b.addVertex(v, 0)
+			continue
+		}
+
+		key := stlVertexKey{[3]float64{v.x, v.y, v.z}, v.normVec}
+		index, ok := b.vertices[key]
+		if !ok {
+			index = b.addVertex(v, 0)
+			b.vertices[key] = index
+		}
+		indices[i] = index
+	}
+
+	b.addTriangle(material, false, indices[0], indices[1], indices[2])
+}
+
+// isBinaryStl tells apart binary files, whose size must match their facet count, from ASCII
+// ones. Checking for the 'solid' keyword alone is not enough, as some exporters also write it
+// at the start of binary headers. Truncated binary files are recognised by their NUL bytes,
+// which never appear in ASCII ones.
+func isBinaryStl(data []byte) bool {
+	if len(data) >= STL_HEADER_SIZE+4 {
+		facets := binary.LittleEndian.Uint32(data[STL_HEADER_SIZE:])
+		if uint64(len(data)) == uint64(STL_HEADER_SIZE+4)+uint64(facets)*uint64(STL_FACET_SIZE) {
+			return true
+		}
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return true
+	}
+
+	return !bytes.HasPrefix(bytes.TrimSpace(data), []byte("solid"))
+}
+
+func parseBinaryStl(ctx context.Context, data []byte, filename string, b *stlBuilder) error {
+	if len(data) < STL_HEADER_SIZE+4 {
+		return &ParseError{File: filename, Msg: "binary STL file too short"}
+	}
+
+	facets := int(binary.LittleEndian.Uint32(data[STL_HEADER_SIZE:]))
+	if len(data) < STL_HEADER_SIZE+4+facets*STL_FACET_SIZE {
+		return &ParseError{File: filename, Msg: fmt.Sprintf("truncated binary STL, expected %d facets", facets)}
+	}
+
+	// Materialise Magics stores a default color in the header, and its per facet colors use the
+	// opposite valid flag and component order of VisCAM/SolidView ones.
+	magics := false
+	defaultMat := defaultMaterial
+	if idx := bytes.Index(data[:STL_HEADER_SIZE], []byte("COLOR=")); idx >= 0 && idx+10 <= STL_HEADER_SIZE {
+		magics = true
+		c := data[idx+6 : idx+10]
+		defaultMat = NewMaterial("")
+		defaultMat.diffuse = NewColorVector(float64(c[0])/255, float64(c[1])/255, float64(c[2])/255)
+	}
+
+	materials := make(map[uint16]*Material)
+
+	readFloat := func(offset int) float64 {
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(data[offset:])))
+	}
+
+	startPhase(ctx, LOAD_FACES, facets)
+
+	for i := 0; i < facets; i++ {
+		if i%PROGRESS_INTERVAL == 0 {
+			if err := reportProgress(ctx, i); err != nil {
+				return err
+			}
+		}
+
+		offset := STL_HEADER_SIZE + 4 + i*STL_FACET_SIZE
+
+		normal := NewNormalVector(readFloat(offset), readFloat(offset+4), readFloat(offset+8))
+
+		var vecs [3]Vector4
+		for v := range vecs {
+			vOffset := offset + 12 + v*12
+			vecs[v] = NewVector4(readFloat(vOffset), readFloat(vOffset+4), readFloat(vOffset+8), 1)
+			vecs[v].normVec = normal
+		}
+
+		material := defaultMat
+		attribute := binary.LittleEndian.Uint16(data[offset+48:])
+		if colorMaterial := stlColorMaterial(materials, attribute, magics); colorMaterial != nil {
+			material = colorMaterial
+		}
+
+		b.addFacet(material, vecs[0], vecs[1], vecs[2])
+	}
+
+	return nil
+}
+
+// stlColorMaterial returns the material for the color stored in the attribute bytes of a facet,
+// or nil if the facet has no color.
+func stlColorMaterial(materials map[uint16]*Material, attribute uint16, magics bool) *Material {
+	valid := attribute&0x8000 != 0
+	if magics {
+		valid = !valid
+	}
+	if !valid || attribute == 0 {
+		return nil
+	}
+
+	if material, ok := materials[attribute]; ok {
+		return material
+	}
+
+	// 5 bits per component. VisCAM/SolidView store blue in the lowest bits, Magics red.
+	low := float64(attribute&0x1f) / 31
+	mid := float64((attribute>>5)&0x1f) / 31
+	high := float64((attribute>>10)&0x1f) / 31
+
+	material := NewMaterial("")
+	if magics {
+		material.diffuse = NewColorVector(low, mid, high)
+	} else {
+		material.diffuse = NewColorVector(high, mid, low)
+	}
+	materials[attribute] = material
+
+	return material
+}
+
+func parseAsciiStl(ctx context.Context, data []byte, filename string, b *stlBuilder) error {
+	var normal NormalVector
+	var polygon []Vector4
+	inLoop := false
+	facets := 0
+
+	parseFloats := func(lineIdx int, line string, parts []string) ([3]float64, error) {
+		values := [3]float64{}
+		if len(parts) < 3 {
+			return values, newParseError(filename, lineIdx, line, "", "expected 3 coordinates")
+		}
+		for i := 0; i < 3; i++ {
+			num, err := strconv.ParseFloat(parts[i], 64)
+			if err != nil {
+				return values, newParseError(filename, lineIdx, line, parts[i], "invalid number")
+			}
+			values[i] = num
+		}
+		return values, nil
+	}
+
+	startPhase(ctx, LOAD_FACES, len(data))
+	offset := 0
+
+	for lineIdx, line := range strings.Split(string(data), "\n") {
+		if lineIdx%PROGRESS_INTERVAL == 0 {
+			if err := reportProgress(ctx, offset); err != nil {
+				return err
+			}
+		}
+		offset += len(line) + 1
+
+		parts := strings.Fields(line)
+		if len(parts) == 0 {
+			continue
+		}
+
+		switch parts[0] {
+		case "facet":
+			facets++
+			normal = NormalVector{}
+			if len(parts) > 1 && parts[1] == "normal" {
+				values, err := parseFloats(lineIdx, line, parts[2:])
+				if err != nil {
+					return err
+				}
+				normal = NewNormalVector(values[0], values[1], values[2])
+			}
+		case "outer":
+			inLoop = true
+			polygon = polygon[:0]
+		case "vertex":
+			if !inLoop {
+				return newParseError(filename, lineIdx, line, parts[0], "vertex outside of a loop")
+			}
+
+			values, err := parseFloats(lineIdx, line, parts[1:])
+			if err != nil {
+				return err
+			}
+
+			vertex := NewVector4(values[0], values[1], values[2], 1)
+			vertex.normVec = normal
+			polygon = append(polygon, vertex)
+		case "endloop":
+			if len(polygon) < 3 {
+				return newParseError(filename, lineIdx, line, parts[0], "a facet needs at least 3 vertices")
+			}
+
+			for _, idx := range TriangulatePolygon(polygon) {
+				b.addFacet(defaultMaterial, polygon[idx[0]], polygon[idx[1]], polygon[idx[2]])
+			}
+			inLoop = false
+		}
+	}
+
+	if facets == 0 {
+		return &ParseError{File: filename, Msg: "no facets found"}
+	}
+
+	return nil
+}
+
+func countUniqueVertices(positions [][3]float64) int {
+	unique := make(map[[3]float64]struct{})
+	for _, p := range positions {
+		unique[p] = struct{}{}
+	}
+
+	return len(unique)
+}
+
+// WriteStl writes the mesh as a binary .stl model, or an ASCII one with opts.ASCII. Binary files
+// keep the material colors as VisCAM/SolidView facet colors.
+func WriteStl(w io.Writer, mesh *Mesh, filename string, opts EncodeOptions) error {
+	if opts.ASCII {
+		return writeAsciiStl(w, mesh)
+	}
+
+	header := make([]byte, STL_HEADER_SIZE+4)
+	copy(header, "Exported by 3d-viewer")
+	binary.LittleEndian.PutUint32(header[STL_HEADER_SIZE:], uint32(mesh.triangleAmount))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	facet := make([]byte, STL_FACET_SIZE)
+	putFloat := func(offset int, value float64) {
+		binary.LittleEndian.PutUint32(facet[offset:], math.Float32bits(float32(value)))
+	}
+
+	for _, submesh := range mesh.submeshes {
+		for i := 0; i+2 < len(submesh.indices); i += 3 {
+			triangle := submesh.indices[i : i+3]
+
+			normal := mesh.faceNormal(triangle[0], triangle[1], triangle[2])
+			putFloat(0, normal.x)
+			putFloat(4, normal.y)
+			putFloat(8, normal.z)
+			for v, index := range triangle {
+				p := mesh.positions[index]
+				putFloat(12+v*12, p[0])
+				putFloat(16+v*12, p[1])
+				putFloat(20+v*12, p[2])
+			}
+
+			attribute := uint16(0)
+			if submesh.material != defaultMaterial || submesh.vertexColors {
+				color := submesh.material.diffuse
+				if submesh.vertexColors && len(mesh.colors) > 0 {
+					c0, c1, c2 := mesh.colors[triangle[0]], mesh.colors[triangle[1]], mesh.colors[triangle[2]]
+					color = color.Mul(c0.Add(c1).Add(c2).Scale(1.0 / 3))
+				}
+				attribute = stlColorAttribute(color)
+			}
+			binary.LittleEndian.PutUint16(facet[48:], attribute)
+
+			if _, err := w.Write(facet); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// stlColorAttribute packs the color as the attribute bytes of a VisCAM/SolidView facet.
+func stlColorAttribute(c ColorVector) uint16 {
+	component := func(value float64) uint16 {
+		return uint16(math.Round(math.Max(0, math.Min(1, value)) * 31))
+	}
+
+	return 0x8000 | component(c.r)<<10 | component(c.g)<<5 | component(c.b)
+}
+
+func writeAsciiStl(w io.Writer, mesh *Mesh) error {
+	buffer := []byte("solid model\n")
+
+	for _, submesh := range mesh.submeshes {
+		for i := 0; i+2 < len(submesh.indices); i += 3 {
+			triangle := submesh.indices[i : i+3]
+
+			normal := mesh.faceNormal(triangle[0], triangle[1], triangle[2])
+			buffer = appendObjFloats(append(buffer, "facet normal"...), normal.x, normal.y, normal.z)
+			buffer = append(buffer, "\n  outer loop\n"...)
+			for _, index := range triangle {
+				buffer = appendObjFloats(append(buffer, "    vertex"...), mesh.positions[index][:]...)
+				buffer = append(buffer, '\n')
+			}
+			buffer = append(buffer, "  endloop\nendfacet\n"...)
+
+			if _, err := w.Write(buffer); err != nil {
+				return err
+			}
+			buffer = buffer[:0]
+		}
+	}
+
+	_, err := w.Write(append(buffer, "endsolid model\n"...))
+	return err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+const TILE_SIZE int = 64            // Width and height of the screen tiles, in pixels
+const PROJECT_BATCH_SIZE int = 1024 // Triangles projected by a worker at a time
+const SUBPIXEL_STEPS float64 = 256  // Precision of the vertex positions when rasterizing
+
+// A screen region rasterized by a single worker, with the triangles overlapping it in draw order
+type tile struct {
+	x0, y0, x1, y1 int // Pixel bounds, the end excluded
+
+	tris   []*Triangle
+	points []int32   // Indices of the projected points of point clouds
+	depth  []float64 // Local copy of the depth buffer while rasterizing, row by row
+}
+
+// The projected triangles of a range of a submesh, and whether each one faces the camera
+type projectedBatch struct {
+	submesh    *Submesh
+	start, end int // Triangles of the submesh
+
+	tris   []Triangle
+	facing []bool
+}
+
+func newTiles(width, height int) []tile {
+	tiles := []tile{}
+
+	for y := 0; y < height; y += TILE_SIZE {
+		for x := 0; x < width; x += TILE_SIZE {
+			t := tile{x0: x, y0: y, x1: min(x+TILE_SIZE, width), y1: min(y+TILE_SIZE, height)}
+			t.depth = make([]float64, (t.x1-t.x0)*(t.y1-t.y0))
+			tiles = append(tiles, t)
+		}
+	}
+
+	return tiles
+}
+
+// parallel calls fn for every index from 0 to n, spread over the renderer workers.
+func (r *Renderer) parallel(n int, fn func(i int)) {
+	workers := min(max(r.workers, 1), n)
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1)) - 1; i < n; i = int(next.Add(1)) - 1 {
+				fn(i)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// binTriangle adds the triangle to every tile its bounding box overlaps, ordering its vertices
+// counter-clockwise as FillTriangle expects (because of EdgeCross order).
+func (r *Renderer) binTriangle(t *Triangle) {
+	// Snapped to a subpixel grid, the edge functions are exact, so pixels right on an edge shared
+	// by two triangles are drawn by exactly one of them
+	for i := range t.vecs {
+		t.vecs[i].x = math.Round(t.vecs[i].x*SUBPIXEL_STEPS) / SUBPIXEL_STEPS
+		t.vecs[i].y = math.Round(t.vecs[i].y*SUBPIXEL_STEPS) / SUBPIXEL_STEPS
+	}
+
+	if IsClockWise(&t.vecs[0], &t.vecs[1], &t.vecs[2]) {
+		t.vecs[0], t.vecs[2] = t.vecs[2], t.vecs[0]
+	}
+	if EdgeCross(&t.vecs[0], &t.vecs[1], &t.vecs[2]) == 0 {
+		return
+	}
+
+	xMin := math.Min(math.Min(t.vecs[0].x, t.vecs[1].x), t.vecs[2].x)
+	yMin := math.Min(math.Min(t.vecs[0].y, t.vecs[1].y), t.vecs[2].y)
+	xMax := math.Max(math.Max(t.vecs[0].x, t.vecs[1].x), t.vecs[2].x)
+	yMax := math.Max(math.Max(t.vecs[0].y, t.vecs[1].y), t.vecs[2].y)
+
+	if xMax < 0 || yMax < 0 || xMin >= r.widthFloat || yMin >= r.heightFloat {
+		return
+	}
+
+	tilesX := (r.width + TILE_SIZE - 1) / TILE_SIZE
+	tx0, ty0 := int(math.Max(xMin, 0))/TILE_SIZE, int(math.Max(yMin, 0))/TILE_SIZE
+	tx1, ty1 := int(math.Min(xMax, r.widthFloat-1))/TILE_SIZE, int(math.Min(yMax, r.heightFloat-1))/TILE_SIZE
+
+	for ty := ty0; ty <= ty1; ty++ {
+		for tx := tx0; tx <= tx1; tx++ {
+			r.tiles[ty*tilesX+tx].tris = append(r.tiles[ty*tilesX+tx].tris, t)
+		}
+	}
+}
+
+// rasterizeTile draws the binned triangles of the tile against its local depth buffer, which is
+// then copied back into the renderer one.
+func (r *Renderer) rasterizeTile(t *tile) {
+	if len(t.tris) == 0 && len(t.points) == 0 {
+		return
+	}
+
+	w := t.x1 - t.x0
+	for y := t.y0; y < t.y1; y++ {
+		copy(t.depth[(y-t.y0)*w:(y-t.y0+1)*w], r.depthBuffer[y*r.width+t.x0:y*r.width+t.x1])
+	}
+
+	for _, tri := range t.tris {
+		r.FillTriangle(&tri.vecs[0], &tri.vecs[1], &tri.vecs[2], tri, t)
+	}
+	for _, point := range t.points {
+		r.fillPoint(&r.points[point], t)
+	}
+
+	for y := t.y0; y < t.y1; y++ {
+		copy(r.depthBuffer[y*r.width+t.x0:y*r.width+t.x1], t.depth[(y-t.y0)*w:(y-t.y0+1)*w])
+	}
+}
@@ -1,26 +1,20 @@
 package main
 
 import (
-	"image/color"
-
 	"math"
 )
 
 type Triangle struct {
-	vecs [3]Vector4
-	ilum float64
-	tex  *Texture
-}
+	vecs     [3]Vector4
+	ilum     ColorVector
+	spec     ColorVector
+	material *Material
 
-const (
-	TRIANGLE_OUTLINE bool = false
-	TRIANGLE_FILL    bool = true
-)
+	// Texture space directions in view space, used by bump and normal maps
+	tangent, bitangent NormalVector
 
-var (
-	TRIANGLE_OUTLINE_COLOR color.RGBA = color.RGBA{255, 255, 255, 255}
-	TRIANGLE_FILL_COLOR    color.RGBA = color.RGBA{255, 255, 255, 255}
-)
+	vertexColors bool // Whether the vertex colors tint the material color
+}
 
 func getZ(x1, y1, z1, x2, y2, z2, x, y float64) float64 {
 	dx := x2 - x1
@@ -44,48 +38,65 @@ func IsClockWise(a, b, c *Vector4) bool {
 	return EdgeCross(a, b, c) < 0
 }
 
-func (t Triangle) Draw() {
-	if TRIANGLE_FILL {
-		// Fill triangle expects the order to be counter-clockwise (because of EdgeCross order)
-		if IsClockWise(&t.vecs[0], &t.vecs[1], &t.vecs[2]) {
-			FillTriangle(&t.vecs[2], &t.vecs[1], &t.vecs[0], t.tex)
-		} else {
-			FillTriangle(&t.vecs[0], &t.vecs[1], &t.vecs[2], t.tex)
-		}
-	}
+// PutPixel shades and writes the pixel if it passes the depth test of the tile it belongs to.
+func (r *Renderer) PutPixel(p *Vector4, tri *Triangle, t *tile) {
+	fx, fy := int((p.x)), int((p.y))
 
-	if TRIANGLE_OUTLINE {
-	}
-}
+	if fx >= t.x0 && fx < t.x1 && fy >= t.y0 && fy < t.y1 {
+		zIdx := (fy-t.y0)*(t.x1-t.x0) + fx - t.x0
+		depth := r.exactDepth(p)
+		if depth < t.depth[zIdx] {
+			// Shadow maps only need the depths, and like in the image translucent faces hide nothing
+			if r.depthOnly {
+				if tri.material == nil || tri.material.opacity >= 1 {
+					t.depth[zIdx] = depth
+				}
+				return
+			}
 
-func PutPixel(p *Vector4, tex *Texture) {
-	fx, fy := int((p.x)), int((p.y))
+			if !r.mode.fills() {
+				t.depth[zIdx] = depth
+				return
+			}
 
-	zIdx := fy*RENDER_WIDTH + fx
+			idx := 4 * (fy*r.width + fx)
+			c, visible := r.shade(p, tri)
+			if !visible {
+				return
+			}
 
-	if zIdx >= 0 && zIdx < depthBufferLength {
-		if p.originalZ < depthBuffer[zIdx] {
-			idx := 4 * (fy*RENDER_WIDTH + fx)
-			c := color.RGBA{255, 0, 255, 255}
-			if tex != nil {
-				c = tex.GetColorAt(p.texVec.u, p.texVec.v)
-				if c.A == 0 {
-					return
-				}
+			// Translucent pixels are blended over what is behind them, without hiding it in the depth buffer
+			if c.A < 255 {
+				alpha := float64(c.A) / 255
+				r.image.Pix[idx+0] = uint8(float64(c.R)*alpha + float64(r.image.Pix[idx+0])*(1-alpha))
+				r.image.Pix[idx+1] = uint8(float64(c.G)*alpha + float64(r.image.Pix[idx+1])*(1-alpha))
+				r.image.Pix[idx+2] = uint8(float64(c.B)*alpha + float64(r.image.Pix[idx+2])*(1-alpha))
+				return
 			}
 
-			renderBuffer[idx+0] = c.B
-			renderBuffer[idx+1] = c.G
-			renderBuffer[idx+2] = c.R
-			renderBuffer[idx+3] = c.A
+			r.image.Pix[idx+0] = c.R
+			r.image.Pix[idx+1] = c.G
+			r.image.Pix[idx+2] = c.B
+			r.image.Pix[idx+3] = c.A
 
-			depthBuffer[zIdx] = p.originalZ
+			t.depth[zIdx] = depth
 		}
 	}
 }
 
-func DrawPoint(v *Vector4, tex *Texture) {
-	PutPixel(v, tex)
+// exactDepth returns the view space depth of the pixel, as stored in the depth buffer. The
+// interpolated view z is only exact with orthographic projections, so with perspective ones it
+// comes from the perspective correct 1/w instead, where w is -z.
+func (r *Renderer) exactDepth(p *Vector4) float64 {
+	if r.projection.m[2][3] != 0 && p.texVec.w != 0 {
+		return -1 / p.texVec.w
+	}
+
+	return p.originalZ
+}
+
+func (r *Renderer) DrawPoint(v *Vector4, tri *Triangle, t *tile) {
+	r.PutPixel(v, tri, t)
 }
 
 func GetSlope(vA, vB Vector4) float64 {
@@ -119,11 +130,12 @@ func isTopLeft(start, end *Vector4) bool {
 	return isTopEdge || isLeftEdge
 }
 
-func FillTriangle(v0, v1, v2 *Vector4, tex *Texture) {
-	xMin := math.Floor(math.Min(math.Min(v0.x, v1.x), v2.x))
-	yMin := math.Floor(math.Min(math.Min(v0.y, v1.y), v2.y))
-	xMax := math.Ceil(math.Max(math.Max(v0.x, v1.x), v2.x))
-	yMax := math.Ceil(math.Max(math.Max(v0.y, v1.y), v2.y))
+// FillTriangle rasterizes the part of the counter-clockwise triangle inside the tile.
+func (r *Renderer) FillTriangle(v0, v1, v2 *Vector4, tri *Triangle, t *tile) {
+	xMin := math.Max(math.Floor(math.Min(math.Min(v0.x, v1.x), v2.x)), float64(t.x0))
+	yMin := math.Max(math.Floor(math.Min(math.Min(v0.y, v1.y), v2.y)), float64(t.y0))
+	xMax := math.Min(math.Ceil(math.Max(math.Max(v0.x, v1.x), v2.x)), float64(t.x1-1))
+	yMax := math.Min(math.Ceil(math.Max(math.Max(v0.y, v1.y), v2.y)), float64(t.y1-1))
 
 	deltaW0Col := v1.y - v2.y
 	deltaW1Col := v2.y - v0.y
@@ -152,7 +164,26 @@ func FillTriangle(v0, v1, v2 *Vector4, tex *Texture) {
 
 	area := EdgeCross(v0, v1, v2)
 
-	p := &Vector4{xMin + 0.5, yMin + 0.5, 0, 0, 0, NewTexVector(0, 0, 0)}
+	// Screen space derivatives of the perspective divided texture coordinates, to know how much
+	// of the texture each pixel covers and pick its mip level
+	m := tri.material
+	textured := r.filter != FILTER_NEAREST && area != 0 &&
+		(m.diffuseMap != nil || m.specularMap != nil || m.alphaMap != nil || m.bumpMap != nil || m.normalMap != nil)
+	var texDx, texDy TexVector
+	if textured {
+		texDx = NewTexVector(
+			(deltaW0Col*v0.texVec.u+deltaW1Col*v1.texVec.u+deltaW2Col*v2.texVec.u)/area,
+			(deltaW0Col*v0.texVec.v+deltaW1Col*v1.texVec.v+deltaW2Col*v2.texVec.v)/area,
+			(deltaW0Col*v0.texVec.w+deltaW1Col*v1.texVec.w+deltaW2Col*v2.texVec.w)/area,
+		)
+		texDy = NewTexVector(
+			(deltaW0Row*v0.texVec.u+deltaW1Row*v1.texVec.u+deltaW2Row*v2.texVec.u)/area,
+			(deltaW0Row*v0.texVec.v+deltaW1Row*v1.texVec.v+deltaW2Row*v2.texVec.v)/area,
+			(deltaW0Row*v0.texVec.w+deltaW1Row*v1.texVec.w+deltaW2Row*v2.texVec.w)/area,
+		)
+	}
+
+	p := &Vector4{x: xMin + 0.5, y: yMin + 0.5}
 
 	w0Row := EdgeCross(v1, v2, p) + bias0
 	w1Row := EdgeCross(v2, v0, p) + bias1
@@ -179,12 +210,38 @@ func FillTriangle(v0, v1, v2 *Vector4, tex *Texture) {
 				p.texVec.w = alpha*v0.texVec.w + beta*v1.texVec.w + gamma*v2.texVec.w
 				p.originalZ = alpha*v0.originalZ + beta*v1.originalZ + gamma*v2.originalZ
 
+				switch r.shading {
+				case SHADING_GOURAUD:
+					p.ilum = v0.ilum.Scale(alpha).Add(v1.ilum.Scale(beta)).Add(v2.ilum.Scale(gamma))
+					p.spec = v0.spec.Scale(alpha).Add(v1.spec.Scale(beta)).Add(v2.spec.Scale(gamma))
+				case SHADING_PHONG:
+					p.normVec.x = alpha*v0.normVec.x + beta*v1.normVec.x + gamma*v2.normVec.x
+					p.normVec.y = alpha*v0.normVec.y + beta*v1.normVec.y + gamma*v2.normVec.y
+					p.normVec.z = alpha*v0.normVec.z + beta*v1.normVec.z + gamma*v2.normVec.z
+				}
+
+				if tri.vertexColors {
+					p.color.r = alpha*v0.color.r + beta*v1.color.r + gamma*v2.color.r
+					p.color.g = alpha*v0.color.g + beta*v1.color.g + gamma*v2.color.g
+					p.color.b = alpha*v0.color.b + beta*v1.color.b + gamma*v2.color.b
+				}
+
+				if textured && p.texVec.w != 0 {
+					p.texVec.footprint = textureFootprint(p.texVec, texDx, texDy)
+				}
+
 				if p.texVec.w != 0 {
 					p.texVec.u /= p.texVec.w
 					p.texVec.v /= p.texVec.w
+					p.ilum = p.ilum.Scale(1 / p.texVec.w)
+					p.spec = p.spec.Scale(1 / p.texVec.w)
+					p.normVec.x /= p.texVec.w
+					p.normVec.y /= p.texVec.w
+					p.normVec.z /= p.texVec.w
+					p.color = p.color.Scale(1 / p.texVec.w)
 				}
 
-				PutPixel(p, tex)
+				r.PutPixel(p, tri, t)
 			}
 			w0 += deltaW0Col
 			w1 += deltaW1Col
@@ -196,10 +253,31 @@ func FillTriangle(v0, v1, v2 *Vector4, tex *Texture) {
 	}
 }
 
+// textureFootprint returns the largest squared distance in texture coordinates to the next pixel
+// in x or y, from the perspective divided coordinates at the pixel and their screen derivatives.
+func textureFootprint(t, dx, dy TexVector) float64 {
+	u, v := t.u/t.w, t.v/t.w
+
+	dux := (t.u+dx.u)/(t.w+dx.w) - u
+	dvx := (t.v+dx.v)/(t.w+dx.w) - v
+	duy := (t.u+dy.u)/(t.w+dy.w) - u
+	dvy := (t.v+dy.v)/(t.w+dy.w) - v
+
+	return math.Max(dux*dux+dvx*dvx, duy*duy+dvy*dvy)
+}
+
 func InterpolateVectors(alpha, beta, gamma float64, v0, v1, v2, target *Vector4) {
 	target.z = alpha*v0.z + beta*v1.z + gamma*v2.z
 	target.texVec.u = alpha*v0.texVec.u + beta*v1.texVec.u + gamma*v2.texVec.u
 	target.texVec.v = alpha*v0.texVec.v + beta*v1.texVec.v + gamma*v2.texVec.v
 	target.texVec.w = alpha*v0.texVec.w + beta*v1.texVec.w + gamma*v2.texVec.w
+	target.normVec.x = alpha*v0.normVec.x + beta*v1.normVec.x + gamma*v2.normVec.x
+	target.normVec.y = alpha*v0.normVec.y + beta*v1.normVec.y + gamma*v2.normVec.y
+	target.normVec.z = alpha*v0.normVec.z + beta*v1.normVec.z + gamma*v2.normVec.z
+	target.ilum = v0.ilum.Scale(alpha).Add(v1.ilum.Scale(beta)).Add(v2.ilum.Scale(gamma))
+	target.spec = v0.spec.Scale(alpha).Add(v1.spec.Scale(beta)).Add(v2.spec.Scale(gamma))
+	target.color.r = alpha*v0.color.r + beta*v1.color.r + gamma*v2.color.r
+	target.color.g = alpha*v0.color.g + beta*v1.color.g + gamma*v2.color.g
+	target.color.b = alpha*v0.color.b + beta*v1.color.b + gamma*v2.color.b
 	target.originalZ = alpha*v0.originalZ + beta*v1.originalZ + gamma*v2.originalZ
 }
@@ -0,0 +1,196 @@
+package main
+
+import "math"
+
+// Relative tolerance (to the polygon size) used to decide if a polygon is planar.
+const PLANAR_TOLERANCE float64 = 1e-4
+
+// TriangulatePolygon splits a polygon into triangles, returned as indices into the given points,
+// keeping the polygon's winding order. Convex planar polygons are fan triangulated, while concave
+// or non-planar ones are ear clipped over their projection on the best fitting plane.
+func TriangulatePolygon(points []Vector4) [][3]int {
+	n := len(points)
+	if n < 3 {
+		return nil
+	}
+	if n == 3 {
+		return [][3]int{{0, 1, 2}}
+	}
+
+	normal := polygonNormal(points)
+	projected := projectPolygon(points, normal)
+
+	if isConvex(projected) && isPlanar(points, normal) {
+		return fanTriangulate(n)
+	}
+
+	return earClip(projected)
+}
+
+// polygonNormal computes the (non normalised) polygon normal using Newell's method,
+// which is robust for concave and slightly non-planar polygons.
+func polygonNormal(points []Vector4) Vector4 {
+	normal := NewVector4(0, 0, 0, 0)
+
+	for i := range points {
+		cur := points[i]
+		next := points[(i+1)%len(points)]
+
+		normal.x += (cur.y - next.y) * (cur.z + next.z)
+		normal.y += (cur.z - next.z) * (cur.x + next.x)
+		normal.z += (cur.x - next.x) * (cur.y + next.y)
+	}
+
+	return normal
+}
+
+// projectPolygon projects the points into 2D by dropping the dominant axis of the normal.
+func projectPolygon(points []Vector4, normal Vector4) [][2]float64 {
+	ax, ay, az := math.Abs(normal.x), math.Abs(normal.y), math.Abs(normal.z)
+
+	projected := make([][2]float64, len(points))
+	for i, p := range points {
+		switch {
+		case ax >= ay && ax >= az:
+			projected[i] = [2]float64{p.y, p.z}
+		case ay >= ax && ay >= az:
+			projected[i] = [2]float64{p.z, p.x}
+		default:
+			projected[i] = [2]float64{p.x, p.y}
+		}
+	}
+
+	return projected
+}
+
+func isPlanar(points []Vector4, normal Vector4) bool {
+	length := normal.Len()
+	if length == 0 {
+		return false
+	}
+	unitNormal := normal.Div(length)
+
+	// Polygon size, to make the tolerance independent of the model scale
+	size := 0.0
+	for i := 1; i < len(points); i++ {
+		size = math.Max(size, points[i].Sub(points[0]).Len())
+	}
+
+	d := unitNormal.Dot(points[0])
+	for _, p := range points[1:] {
+		if math.Abs(unitNormal.Dot(p)-d) > size*PLANAR_TOLERANCE {
+			return false
+		}
+	}
+
+	return true
+}
+
+func cross2D(a, b, c [2]float64) float64 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+func signedArea2D(points [][2]float64) float64 {
+	area := 0.0
+	for i := range points {
+		cur := points[i]
+		next := points[(i+1)%len(points)]
+		area += cur[0]*next[1] - next[0]*cur[1]
+	}
+
+	return area / 2
+}
+
+func isConvex(points [][2]float64) bool {
+	n := len(points)
+	sign := 0.0
+
+	for i := 0; i < n; i++ {
+		turn := cross2D(points[i], points[(i+1)%n], points[(i+2)%n])
+		if turn == 0 {
+			continue
+		}
+		if sign == 0 {
+			sign = math.Copysign(1, turn)
+		} else if math.Copysign(1, turn) != sign {
+			return false
+		}
+	}
+
+	return true
+}
+
+func fanTriangulate(n int) [][3]int {
+	tris := make([][3]int, 0, n-2)
+	for i := 1; i < n-1; i++ {
+		tris = append(tris, [3]int{0, i, i + 1})
+	}
+
+	return tris
+}
+
+func pointInTriangle2D(p, a, b, c [2]float64) bool {
+	d1 := cross2D(a, b, p)
+	d2 := cross2D(b, c, p)
+	d3 := cross2D(c, a, p)
+
+	hasNeg := d1 < 0 || d2 < 0 || d3 < 0
+	hasPos := d1 > 0 || d2 > 0 || d3 > 0
+
+	return !(hasNeg && hasPos)
+}
+
+// earClip triangulates a simple 2D polygon by repeatedly cutting off its ears.
+func earClip(points [][2]float64) [][3]int {
+	orientation := math.Copysign(1, signedArea2D(points))
+
+	remaining := make([]int, len(points))
+	for i := range remaining {
+		remaining[i] = i
+	}
+
+	tris := make([][3]int, 0, len(points)-2)
+
+	for len(remaining) > 3 {
+		n := len(remaining)
+		earFound := false
+
+		for i := 0; i < n; i++ {
+			prev, cur, next := remaining[(i+n-1)%n], remaining[i], remaining[(i+1)%n]
+			a, b, c := points[prev], points[cur], points[next]
+
+			// Reflex or degenerate corners can't be ears
+			if cross2D(a, b, c)*orientation <= 0 {
+				continue
+			}
+
+			isEar := true
+			for _, other := range remaining {
+				if other == prev || other == cur || other == next {
+					continue
+				}
+				if pointInTriangle2D(points[other], a, b, c) {
+					isEar = false
+					break
+				}
+			}
+
+			if isEar {
+				tris = append(tris, [3]int{prev, cur, next})
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				earFound = true
+				break
+			}
+		}
+
+		// Self intersecting or fully degenerate polygon: fan triangulate what is left
+		if !earFound {
+			for i := 1; i < len(remaining)-1; i++ {
+				tris = append(tris, [3]int{remaining[0], remaining[i], remaining[i+1]})
+			}
+			return tris
+		}
+	}
+
+	return append(tris, [3]int{remaining[0], remaining[1], remaining[2]})
+}
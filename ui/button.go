@@ -6,6 +6,7 @@ type Button struct {
 	bX, bY  int32
 	bH, bW  int32
 	bAnchor Anchor
+	bMargin Margin
 
 	pressed bool
 	hovered bool
@@ -22,6 +23,7 @@ func NewButton(x, y, w, h int32, margin Margin, anchor Anchor, colorIdle, colorH
 		bW:      w,
 		bH:      h,
 		bAnchor: anchor,
+		bMargin: margin,
 
 		pressed:    false,
 		hovered:    false,
@@ -35,6 +37,17 @@ func NewButton(x, y, w, h int32, margin Margin, anchor Anchor, colorIdle, colorH
 	return btn
 }
 
+func (b *Button) SetColors(colorIdle, colorHover, colorPress uint32) {
+	b.colorIdle = colorIdle
+	b.colorHover = colorHover
+	b.colorPress = colorPress
+}
+
+func (b *Button) SetPosition(x, y int32) {
+	b.bX, b.bY = x, y
+	b.rect = GetFinalRect(x, y, b.bW, b.bH, b.bMargin, Padding{}, b.bAnchor)
+}
+
 func (b Button) Draw(surface *sdl.Surface) {
 	if b.pressed {
 		surface.FillRect(b.rect, b.colorPress)
@@ -45,8 +58,13 @@ func (b Button) Draw(surface *sdl.Surface) {
 	}
 }
 
+// Contains returns whether the point is over the button.
+func (b Button) Contains(x, y int32) bool {
+	return x >= b.rect.X && x <= b.rect.X+b.rect.W && y >= b.rect.Y && y <= b.rect.Y+b.rect.H
+}
+
 func (b *Button) UpdateAndGetStatus(x, y int32, pressing bool) bool {
-	b.hovered = x >= b.rect.X && x <= b.rect.X+b.rect.W && y >= b.rect.Y && y <= b.rect.Y+b.rect.H
+	b.hovered = b.Contains(x, y)
 	pressed := pressing && b.hovered
 
 	if b.pressed && pressed { // Still clicking, wait until release
@@ -35,6 +35,28 @@ func (cb ContentBlock) Draw(surface *sdl.Surface) {
 	surface.FillRect(cb.rect, cb.backgroundColor)
 }
 
+// Contains returns whether the point is over the block.
+func (cb ContentBlock) Contains(x, y int32) bool {
+	return x >= cb.rect.X && x <= cb.rect.X+cb.rect.W && y >= cb.rect.Y && y <= cb.rect.Y+cb.rect.H
+}
+
+// Overlaps returns whether the blocks cover a common area.
+func (cb ContentBlock) Overlaps(other ContentBlock) bool {
+	a, b := cb.rect, other.rect
+	return a.X < b.X+b.W && b.X < a.X+a.W && a.Y < b.Y+b.H && b.Y < a.Y+a.H
+}
+
 func (cb *ContentBlock) UpdateRectToWidth(width int32) {
+	cb.bW = width
 	cb.rect = GetFinalRect(cb.bX, cb.bY, width, cb.bH, cb.bMargin, cb.bPadding, cb.bAnchor)
 }
+
+func (cb *ContentBlock) UpdateRectToHeight(height int32) {
+	cb.bH = height
+	cb.rect = GetFinalRect(cb.bX, cb.bY, cb.bW, height, cb.bMargin, cb.bPadding, cb.bAnchor)
+}
+
+func (cb *ContentBlock) SetPosition(x, y int32) {
+	cb.bX, cb.bY = x, y
+	cb.rect = GetFinalRect(x, y, cb.bW, cb.bH, cb.bMargin, cb.bPadding, cb.bAnchor)
+}
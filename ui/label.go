@@ -54,6 +54,13 @@ func (lbl *Label) SetText(text string) {
 	lbl.updateRender()
 }
 
+func (lbl *Label) SetPosition(x, y int32) {
+	lbl.bX, lbl.bY = x, y
+	if lbl.rendered != nil {
+		lbl.rect = GetFinalRect(x, y, lbl.rendered.W, lbl.rendered.H, lbl.bMargin, Padding{0, 0}, lbl.bAnchor)
+	}
+}
+
 func (lbl Label) Draw(surface *sdl.Surface) {
 	if err := lbl.rendered.Blit(nil, surface, lbl.rect); err != nil {
 		zenity.Error(fmt.Sprintf("Error rendering a label.\n%s", err), zenity.Title("UI error"), zenity.ErrorIcon)
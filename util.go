@@ -5,3 +5,7 @@ import "math"
 func degToRad(deg float64) float64 {
 	return deg * math.Pi / 180
 }
+
+func radToDeg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
@@ -6,17 +6,66 @@ import (
 
 type TexVector struct {
 	u, v, w float64
+
+	footprint float64 // Squared texture coordinates distance to the neighbouring pixels, to pick mip levels
 }
 
 func NewTexVector(u, v, w float64) TexVector {
-	return TexVector{u, v, w}
+	return TexVector{u: u, v: v, w: w}
+}
+
+// NormalVector is the vertex normal, kept along each vertex (like its texture
+// coordinates) so it can be interpolated across the triangle.
+type NormalVector struct {
+	x, y, z float64
+}
+
+func NewNormalVector(x, y, z float64) NormalVector {
+	return NormalVector{x, y, z}
+}
+
+func (n NormalVector) IsZero() bool {
+	return n.x == 0 && n.y == 0 && n.z == 0
+}
+
+func (n1 NormalVector) Add(n2 NormalVector) NormalVector {
+	return NormalVector{n1.x + n2.x, n1.y + n2.y, n1.z + n2.z}
+}
+
+func (n NormalVector) Normalise() NormalVector {
+	l := math.Sqrt(n.x*n.x + n.y*n.y + n.z*n.z)
+	if l == 0 {
+		return n
+	}
+	return NormalVector{n.x / l, n.y / l, n.z / l}
+}
+
+func (n NormalVector) Scale(k float64) NormalVector {
+	return NormalVector{n.x * k, n.y * k, n.z * k}
+}
+
+func (n1 NormalVector) DotNormal(n2 NormalVector) float64 {
+	return n1.x*n2.x + n1.y*n2.y + n1.z*n2.z
+}
+
+func (n NormalVector) Dot(v Vector4) float64 {
+	return n.x*v.x + n.y*v.y + n.z*v.z
 }
 
 type Vector4 struct {
 	x, y, z, w float64
 	originalZ  float64
 
-	texVec TexVector
+	texVec  TexVector
+	normVec NormalVector
+	ilum    ColorVector // Per vertex diffuse and specular illumination, for Gouraud shading
+	spec    ColorVector
+	color   ColorVector // Vertex color, only used by triangles with vertexColors
+}
+
+// NewVector4 creates a vector without texture, normal or illumination data.
+func NewVector4(x, y, z, w float64) Vector4 {
+	return Vector4{x: x, y: y, z: z, w: w, originalZ: -1}
 }
 
 func (v1 Vector4) Add(v2 Vector4) Vector4 {
@@ -31,6 +80,14 @@ func (v1 Vector4) Add(v2 Vector4) Vector4 {
 			v1.texVec.v+v2.texVec.v,
 			v1.texVec.w+v2.texVec.w,
 		),
+		NewNormalVector(
+			v1.normVec.x+v2.normVec.x,
+			v1.normVec.y+v2.normVec.y,
+			v1.normVec.z+v2.normVec.z,
+		),
+		v1.ilum.Add(v2.ilum),
+		v1.spec.Add(v2.spec),
+		v1.color.Add(v2.color),
 	}
 }
 
@@ -46,6 +103,14 @@ func (v1 Vector4) Sub(v2 Vector4) Vector4 {
 			v1.texVec.v-v2.texVec.v,
 			v1.texVec.w-v2.texVec.w,
 		),
+		NewNormalVector(
+			v1.normVec.x-v2.normVec.x,
+			v1.normVec.y-v2.normVec.y,
+			v1.normVec.z-v2.normVec.z,
+		),
+		v1.ilum.Add(v2.ilum.Scale(-1)),
+		v1.spec.Add(v2.spec.Scale(-1)),
+		v1.color.Add(v2.color.Scale(-1)),
 	}
 }
 
@@ -61,6 +126,10 @@ func (v Vector4) Mul(k float64) Vector4 {
 			v.texVec.v,
 			v.texVec.w,
 		),
+		v.normVec,
+		v.ilum,
+		v.spec,
+		v.color,
 	}
 }
 
@@ -76,6 +145,10 @@ func (v Vector4) Div(k float64) Vector4 {
 			v.texVec.v,
 			v.texVec.w,
 		),
+		v.normVec,
+		v.ilum,
+		v.spec,
+		v.color,
 	}
 }
 
@@ -100,6 +173,10 @@ func (v Vector4) Normalise() Vector4 {
 			v.texVec.v,
 			v.texVec.w,
 		),
+		v.normVec,
+		v.ilum,
+		v.spec,
+		v.color,
 	}
 }
 
@@ -115,6 +192,10 @@ func (v1 Vector4) CrossProduct(v2 Vector4) Vector4 {
 			v1.texVec.v,
 			v1.texVec.w,
 		),
+		v1.normVec,
+		v1.ilum,
+		v1.spec,
+		v1.color,
 	}
 }
 
@@ -183,14 +264,11 @@ func ClipAgainstPlane(plane_p, plane_n Vector4, in_tri Triangle) []Triangle {
 		updateVector(&p1)
 		updateVector(&p2)
 
-		outTri := Triangle{
-			vecs: [3]Vector4{
-				insidePoints[0],
-				p1,
-				p2,
-			},
-			ilum: in_tri.ilum,
-			tex:  in_tri.tex,
+		outTri := in_tri
+		outTri.vecs = [3]Vector4{
+			insidePoints[0],
+			p1,
+			p2,
 		}
 
 		return []Triangle{outTri}
@@ -201,27 +279,21 @@ func ClipAgainstPlane(plane_p, plane_n Vector4, in_tri Triangle) []Triangle {
 		p1 := IntersectPlane(plane_p, planeN, insidePoints[0], outsidePoints[0])
 		updateVector(&p1)
 
-		outTri1 := Triangle{
-			vecs: [3]Vector4{
-				insidePoints[0],
-				insidePoints[1],
-				p1,
-			},
-			ilum: in_tri.ilum,
-			tex:  in_tri.tex,
+		outTri1 := in_tri
+		outTri1.vecs = [3]Vector4{
+			insidePoints[0],
+			insidePoints[1],
+			p1,
 		}
 
 		p2 := IntersectPlane(plane_p, planeN, insidePoints[1], outsidePoints[0])
 		updateVector(&p2)
 
-		outTri2 := Triangle{
-			vecs: [3]Vector4{
-				insidePoints[1],
-				outTri1.vecs[2],
-				p2,
-			},
-			ilum: in_tri.ilum,
-			tex:  in_tri.tex,
+		outTri2 := in_tri
+		outTri2.vecs = [3]Vector4{
+			insidePoints[1],
+			outTri1.vecs[2],
+			p2,
 		}
 
 		return []Triangle{outTri1, outTri2}
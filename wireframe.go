@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type RenderMode int
+
+const (
+	RENDER_SHADED       RenderMode = iota
+	RENDER_WIREFRAME               // Every edge, including the hidden and back facing ones
+	RENDER_HIDDEN_LINE             // Only the edges not hidden by other faces
+	RENDER_SHADED_EDGES            // Shaded faces with their visible edges over them
+)
+
+var renderModeNames = []string{"Shaded", "Wireframe", "Hidden line", "Shaded edges"}
+
+func (m RenderMode) String() string {
+	return renderModeNames[m]
+}
+
+// ParseRenderMode accepts the mode names with dashes instead of spaces, e.g. 'hidden-line'.
+func ParseRenderMode(name string) (RenderMode, error) {
+	for i, modeName := range renderModeNames {
+		if strings.EqualFold(strings.ReplaceAll(name, "-", " "), modeName) {
+			return RenderMode(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown render mode '%s'", name)
+}
+
+// Whether the mode fills the triangles, and whether it draws their edges
+func (m RenderMode) fills() bool { return m == RENDER_SHADED || m == RENDER_SHADED_EDGES }
+func (m RenderMode) lines() bool { return m != RENDER_SHADED }
+
+const LINE_DEPTH_BIAS float64 = 0.002 // Relative to the depth, so edges are not hidden by their own faces
+
+var DEFAULT_LINE_COLOR color.RGBA = color.RGBA{255, 255, 255, 255}
+
+// ParseLineColor reads colors in the '#rrggbb' or 'rrggbb' forms.
+func ParseLineColor(value string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color '%s', expected #rrggbb", value)
+	}
+
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color '%s', expected #rrggbb", value)
+	}
+
+	return color.RGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 255}, nil
+}
+
+type edgeKey struct {
+	x0, y0, x1, y1 float64
+}
+
+// addEdges queues the edges of the projected triangle to be drawn after the faces, skipping the
+// ones already queued by a neighbouring triangle so they are not blended twice.
+func (r *Renderer) addEdges(t *Triangle, seen map[edgeKey]bool) {
+	for i := 0; i < 3; i++ {
+		a, b := t.vecs[i], t.vecs[(i+1)%3]
+		if a.x > b.x || (a.x == b.x && a.y > b.y) {
+			a, b = b, a
+		}
+
+		key := edgeKey{a.x, a.y, b.x, b.y}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		r.edges = append(r.edges, [2]Vector4{a, b})
+	}
+}
+
+// DrawLine draws an anti-aliased line of the renderer's line width and color between two screen
+// space points, optionally depth tested against the faces already drawn.
+func (r *Renderer) DrawLine(a, b *Vector4, depthTest bool) {
+	halfWidth := math.Max(r.lineWidth, 0.1) / 2
+
+	dx, dy := b.x-a.x, b.y-a.y
+	lengthSq := dx*dx + dy*dy
+
+	// Iterate over the major axis, covering the line width (and its anti-aliased border) on the minor one
+	steep := math.Abs(dy) > math.Abs(dx)
+	major0, major1 := a.x, b.x
+	if steep {
+		major0, major1 = a.y, b.y
+	}
+	if major0 > major1 {
+		major0, major1 = major1, major0
+	}
+
+	extent := halfWidth + 1
+	if lengthSq > 0 {
+		// The minor axis extent of the line grows as it gets closer to 45 degrees
+		length := math.Sqrt(lengthSq)
+		if steep {
+			extent *= length / math.Abs(dy)
+		} else {
+			extent *= length / math.Abs(dx)
+		}
+	}
+
+	maxMajor := r.width
+	if steep {
+		maxMajor = r.height
+	}
+
+	start := int(math.Max(0, math.Floor(major0-halfWidth-1)))
+	end := int(math.Min(float64(maxMajor-1), math.Ceil(major1+halfWidth+1)))
+
+	for m := start; m <= end; m++ {
+		center := float64(m) + 0.5
+
+		// Position of the line on the minor axis at this column (or row)
+		var minorCenter float64
+		switch {
+		case steep && dy != 0:
+			minorCenter = a.x + (center-a.y)*dx/dy
+		case !steep && dx != 0:
+			minorCenter = a.y + (center-a.x)*dy/dx
+		case steep:
+			minorCenter = a.x
+		default:
+			minorCenter = a.y
+		}
+
+		for n := int(math.Floor(minorCenter - extent)); n <= int(math.Ceil(minorCenter+extent)); n++ {
+			px, py := m, n
+			if steep {
+				px, py = n, m
+			}
+			if px < 0 || py < 0 || px >= r.width || py >= r.height {
+				continue
+			}
+
+			// Distance from the pixel center to the segment, with rounded ends
+			cx, cy := float64(px)+0.5, float64(py)+0.5
+			t := 0.0
+			if lengthSq > 0 {
+				t = math.Max(0, math.Min(1, ((cx-a.x)*dx+(cy-a.y)*dy)/lengthSq))
+			}
+			distance := math.Hypot(cx-(a.x+t*dx), cy-(a.y+t*dy))
+
+			coverage := math.Min(1, halfWidth+0.5-distance)
+			if coverage <= 0 {
+				continue
+			}
+
+			idx := py*r.width + px
+			if depthTest {
+				// Perspective correct like the depth buffer, as 1/z is linear in screen space
+				z := a.originalZ * b.originalZ / (b.originalZ + t*(a.originalZ-b.originalZ))
+				if z*(1-LINE_DEPTH_BIAS) > r.farthestDepthAround(px, py) {
+					continue
+				}
+			}
+
+			alpha := coverage * float64(r.lineColor.A) / 255
+			pix := r.image.Pix[idx*4 : idx*4+3]
+			pix[0] = uint8(float64(r.lineColor.R)*alpha + float64(pix[0])*(1-alpha))
+			pix[1] = uint8(float64(r.lineColor.G)*alpha + float64(pix[1])*(1-alpha))
+			pix[2] = uint8(float64(r.lineColor.B)*alpha + float64(pix[2])*(1-alpha))
+		}
+	}
+}
+
+// farthestDepthAround returns the farthest depth of the pixel and its neighbours. Faces seen at
+// grazing angles change depth quickly across a pixel, which would hide parts of their own edges.
+func (r *Renderer) farthestDepthAround(x, y int) float64 {
+	farthest := 0.0
+	for ny := y - 1; ny <= y+1; ny++ {
+		for nx := x - 1; nx <= x+1; nx++ {
+			if nx >= 0 && ny >= 0 && nx < r.width && ny < r.height {
+				farthest = math.Max(farthest, r.depthBuffer[ny*r.width+nx])
+			}
+		}
+	}
+
+	return farthest
+}
+
+// drawEdges draws the queued triangle edges over the rendered faces.
+func (r *Renderer) drawEdges() {
+	depthTest := r.mode != RENDER_WIREFRAME
+	for i := range r.edges {
+		r.DrawLine(&r.edges[i][0], &r.edges[i][1], depthTest)
+	}
+
+	r.edges = r.edges[:0]
+}